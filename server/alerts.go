@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const alertSendTimeout = 30 * time.Second
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// Alert is sent to every sink when a rule fires or resolves
+type Alert struct {
+	Rule    string `json:"rule"`
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+}
+
+// Alerts evaluates rules against cached source data after every cache
+// update, and notifies sinks when a rule starts or stops matching.
+type Alerts struct {
+	cache    *Cache
+	lastPush func() time.Time
+	loc      *time.Location
+	started  time.Time
+
+	mu       sync.Mutex
+	rules    []AlertRuleConfig
+	sinks    []AlertSink
+	cooldown time.Duration
+	states   map[string]*alertState
+}
+
+type alertState struct {
+	since     time.Time // condition matching since, zero if not
+	active    bool
+	subject   string // what matched, e.g. a date, a new subject fires again
+	message   string
+	notified  bool // firing sent, resolve due
+	lastFired time.Time
+}
+
+// Outcome of a rule evaluation. Rules whose data is unavailable are left
+// in their current state.
+type alertMatch struct {
+	known   bool
+	match   bool
+	subject string
+	message string
+}
+
+// Create the alerts engine. lastPush tells the time of the last sensor
+// push, zero if none.
+func NewAlerts(cache *Cache, cfg AlertsConfig, lastPush func() time.Time) *Alerts {
+	loc, _ := time.LoadLocation("Europe/Paris")
+	if loc == nil {
+		loc = time.Local
+	}
+	a := &Alerts{
+		cache:    cache,
+		lastPush: lastPush,
+		loc:      loc,
+		started:  clock.Now(),
+		states:   make(map[string]*alertState),
+	}
+	a.SetConfig(cfg)
+	return a
+}
+
+// Replace rules and sinks, keeping the state of rules by name
+func (a *Alerts) SetConfig(cfg AlertsConfig) {
+	sinks := make([]AlertSink, 0, len(cfg.Sinks))
+	for _, sc := range cfg.Sinks {
+		sinks = append(sinks, newAlertSink(sc))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rules = cfg.Rules
+	a.sinks = sinks
+	a.cooldown = cfg.Cooldown
+
+	states := make(map[string]*alertState)
+	for _, rule := range cfg.Rules {
+		if st := a.states[rule.Name]; st != nil {
+			states[rule.Name] = st
+		} else {
+			states[rule.Name] = &alertState{}
+		}
+	}
+	a.states = states
+}
+
+// Source whose updates a rule type is evaluated on. sensor_silent rules
+// follow the temperature source, refreshed on schedule without pushes.
+func alertSource(ruleType string) string {
+	switch ruleType {
+	case "tempo":
+		return "tempo"
+	case "forecast":
+		return "weather"
+	case "temperature", "humidity", "sensor_silent":
+		return "temperature"
+	case "no_departures":
+		return "transport"
+	}
+	return ""
+}
+
+// Cache listener evaluating the rules of the updated source
+func (a *Alerts) OnSet(key string, _, _ *Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := clock.Now()
+	for _, rule := range a.rules {
+		source := alertSource(rule.Type)
+		if source != "" && source != key {
+			continue
+		}
+		if m := a.evaluate(rule, now); m.known {
+			a.apply(rule, m, now)
+		}
+	}
+}
+
+// Evaluate a rule against the latest data of its source
+func (a *Alerts) evaluate(rule AlertRuleConfig, now time.Time) alertMatch {
+	resp := a.cache.Peek(alertSource(rule.Type))
+
+	switch rule.Type {
+	case "tempo":
+		data, ok := decodeData[TempoData](resp)
+		if !ok {
+			return alertMatch{}
+		}
+		day, name := now.In(a.loc).AddDate(0, 0, 1), "tomorrow"
+		if rule.Day == "today" {
+			day, name = now.In(a.loc), "today"
+		}
+		date := day.Format(time.DateOnly)
+		for _, d := range data {
+			if d.Date == date {
+				return alertMatch{
+					known:   true,
+					match:   d.Color == rule.Color,
+					subject: date,
+					message: fmt.Sprintf("Tempo %s (%s) is %s", name, date, d.Color),
+				}
+			}
+		}
+		// Not published yet
+		return alertMatch{}
+
+	case "forecast":
+		data, ok := decodeData[WeatherData](resp)
+		if !ok || len(data.Hourly) == 0 {
+			return alertMatch{}
+		}
+		for _, h := range data.Hourly {
+			if rule.outside(h.Temperature) {
+				return alertMatch{
+					known:   true,
+					match:   true,
+					message: fmt.Sprintf("Forecast %.1f °C at %s (%s)", h.Temperature, h.Time, rule.thresholds("°C")),
+				}
+			}
+		}
+		return alertMatch{known: true}
+
+	case "temperature", "humidity":
+		// Readings of silent sensors are too old to alert on
+		data, ok := decodeData[TemperatureData](resp)
+		if !ok || a.sensorsSilent(now) {
+			return alertMatch{}
+		}
+		value, unit, label := data.Temperature, "°C", "Temperature"
+		if rule.Type == "humidity" {
+			value, unit, label = data.Humidity, "%", "Humidity"
+		}
+		msg := fmt.Sprintf("%s %.1f %s in %s (%s)", label, value, unit, data.Location, rule.thresholds(unit))
+		if rule.For > 0 {
+			msg += " for " + rule.For.String()
+		}
+		return alertMatch{known: true, match: rule.outside(value), message: msg}
+
+	case "sensor_silent":
+		silent := now.Sub(a.lastPushOrStart())
+		return alertMatch{
+			known:   true,
+			match:   silent >= rule.For,
+			message: fmt.Sprintf("No sensor push for %s", silent.Round(time.Minute)),
+		}
+
+	case "no_departures":
+		data, ok := decodeData[TransportData](resp)
+		if !ok {
+			return alertMatch{}
+		}
+		var empty []string
+		for _, stop := range data.Stops {
+			if (rule.Line != "" && stop.Line != rule.Line) || (rule.Stop != "" && stop.Name != rule.Stop) {
+				continue
+			}
+			departures := 0
+			for _, dest := range stop.Destinations {
+				departures += len(dest.Departures)
+			}
+			if departures == 0 {
+				empty = append(empty, stop.Line+" "+stop.Name)
+			}
+		}
+		subject := strings.Join(empty, ", ")
+		return alertMatch{
+			known:   true,
+			match:   len(empty) > 0,
+			subject: subject,
+			message: "No departures at " + subject,
+		}
+	}
+	return alertMatch{}
+}
+
+// Time of the last sensor push, or of the start without any
+func (a *Alerts) lastPushOrStart() time.Time {
+	if last := a.lastPush(); !last.IsZero() {
+		return last
+	}
+	return a.started
+}
+
+// Whether sensors have not pushed for the threshold of a sensor_silent
+// rule. Called with a.mu held.
+func (a *Alerts) sensorsSilent(now time.Time) bool {
+	silent := now.Sub(a.lastPushOrStart())
+	for _, rule := range a.rules {
+		if rule.Type == "sensor_silent" && silent >= rule.For {
+			return true
+		}
+	}
+	return false
+}
+
+// Update the state of a rule and notify transitions. Called with a.mu held.
+func (a *Alerts) apply(rule AlertRuleConfig, m alertMatch, now time.Time) {
+	st := a.states[rule.Name]
+
+	if !m.match {
+		st.since = time.Time{}
+		if st.active {
+			st.active = false
+			if st.notified {
+				st.notified = false
+				a.notify(rule, alertResolved, st.message, now)
+			}
+		}
+		return
+	}
+
+	// sensor_silent uses For as its threshold rather than a hold time
+	if st.since.IsZero() {
+		st.since = now
+	}
+	if rule.Type != "sensor_silent" && now.Sub(st.since) < rule.For {
+		return
+	}
+	if st.active && st.subject == m.subject {
+		return
+	}
+	st.active = true
+	st.subject = m.subject
+
+	cooldown := rule.Cooldown
+	if cooldown == 0 {
+		cooldown = a.cooldown
+	}
+	if !st.lastFired.IsZero() && now.Sub(st.lastFired) < cooldown {
+		log.Printf("[alerts] %s: %s (cooldown, not notified)", rule.Name, m.message)
+		return
+	}
+	st.message = m.message
+	st.notified = true
+	st.lastFired = now
+	a.notify(rule, alertFiring, m.message, now)
+}
+
+// Send an alert to every sink in the background. Called with a.mu held.
+func (a *Alerts) notify(rule AlertRuleConfig, status, message string, now time.Time) {
+	alert := Alert{
+		Rule:    rule.Name,
+		Type:    rule.Type,
+		Status:  status,
+		Message: message,
+		Time:    now.UTC().Format(time.RFC3339),
+	}
+	log.Printf("[alerts] %s %s: %s", rule.Name, status, message)
+	metricAlertNotifications.Inc(rule.Name, status)
+
+	sinks := a.sinks
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertSendTimeout)
+		defer cancel()
+		for _, sink := range sinks {
+			if err := sink.Send(ctx, alert); err != nil {
+				metricAlertSinkErrors.Inc(sink.Name())
+				log.Printf("[alerts] %s: %v", sink.Name(), err)
+			}
+		}
+	}()
+}
+
+// Check a value against the below and above thresholds of a rule
+func (r AlertRuleConfig) outside(v float64) bool {
+	return (r.Below != nil && v < *r.Below) || (r.Above != nil && v > *r.Above)
+}
+
+// Describe the thresholds of a rule
+func (r AlertRuleConfig) thresholds(unit string) string {
+	var parts []string
+	if r.Below != nil {
+		parts = append(parts, fmt.Sprintf("below %g %s", *r.Below, unit))
+	}
+	if r.Above != nil {
+		parts = append(parts, fmt.Sprintf("above %g %s", *r.Above, unit))
+	}
+	return strings.Join(parts, " or ")
+}
+
+// Decode the data of a successful response
+func decodeData[T any](resp *Response) (T, bool) {
+	if resp == nil || resp.Error != "" {
+		var v T
+		return v, false
+	}
+	return decodeValue[T](resp.Data)
+}
+
+// Convert response data to T, decoding it when it is raw JSON restored from
+// the cache snapshot
+func decodeValue[T any](data any) (T, bool) {
+	var v T
+	if data == nil {
+		return v, false
+	}
+	if d, ok := data.(T); ok {
+		return d, true
+	}
+	b, err := json.Marshal(data)
+	if err != nil || json.Unmarshal(b, &v) != nil {
+		return v, false
+	}
+	return v, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func float(v float64) *float64 { return &v }
+
+// sensor_silent rules are evaluated on temperature updates only
+func TestAlertsSensorSilent(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	last, calls := clock.Now(), 0
+	cache := NewCache()
+	a := NewAlerts(cache, AlertsConfig{Rules: []AlertRuleConfig{
+		{Name: "silent", Type: "sensor_silent", For: time.Hour},
+	}}, func() time.Time { calls++; return last })
+	cache.OnSet(a.OnSet)
+
+	c.Advance(2 * time.Hour)
+	cache.Set("weather", NewResponse(WeatherData{}, time.Minute), time.Hour)
+	cache.Set("tempo", NewResponse(TempoData{}, time.Minute), time.Hour)
+	if calls != 0 || a.states["silent"].active {
+		t.Errorf("evaluated on other sources: %d calls, active %v", calls, a.states["silent"].active)
+	}
+	cache.Set("temperature", ErrorResponse("no sensor data", time.Minute), time.Hour)
+	if !a.states["silent"].active {
+		t.Error("not firing after 2 hours without push")
+	}
+
+	last = clock.Now()
+	cache.Set("temperature", NewResponse(TemperatureData{Temperature: 20}, time.Minute), time.Hour)
+	if a.states["silent"].active {
+		t.Error("still firing after a push")
+	}
+}
+
+// Readings of silent sensors neither fire nor resolve value rules
+func TestAlertsSilentReadings(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	last := clock.Now()
+	cache := NewCache()
+	a := NewAlerts(cache, AlertsConfig{Rules: []AlertRuleConfig{
+		{Name: "cold", Type: "temperature", Below: float(16)},
+		{Name: "dry", Type: "humidity", Below: float(30)},
+		{Name: "silent", Type: "sensor_silent", For: time.Hour},
+	}}, func() time.Time { return last })
+	cache.OnSet(a.OnSet)
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		push    bool
+		temp    float64
+		cold    bool
+	}{
+		{"fresh warm reading", time.Minute, true, 20, false},
+		{"stale cold reading", 2 * time.Hour, false, 15, false},
+		{"fresh cold reading", time.Minute, true, 15, true},
+		{"stale warm reading", 2 * time.Hour, false, 20, true},
+		{"fresh warm reading again", time.Minute, true, 20, false},
+	}
+	for _, tt := range tests {
+		c.Advance(tt.advance)
+		if tt.push {
+			last = clock.Now()
+		}
+		hum := 20.0
+		if !tt.push {
+			hum = 50
+		}
+		cache.Set("temperature", NewResponse(TemperatureData{Temperature: tt.temp, Humidity: hum}, time.Minute), time.Hour)
+		if st := a.states["cold"]; st.active != tt.cold {
+			t.Errorf("%s: cold active %v, want %v", tt.name, st.active, tt.cold)
+		}
+		if !a.states["dry"].active {
+			t.Errorf("%s: dry resolved", tt.name)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie = "strasboard_session"
+	csrfCookie    = "strasboard_csrf"
+
+	// Failed authentications allowed per client and window
+	authMaxFailures   = 5
+	authFailureWindow = 5 * time.Minute
+)
+
+// Auth restricts the dashboard and API to configured users, API tokens and
+// kiosks. It is disabled until at least one of them is configured.
+type Auth struct {
+	limiter *failureLimiter
+
+	mu  sync.RWMutex
+	cfg AuthConfig
+	key []byte
+}
+
+// Authenticated user or kiosk, bound to a fingerprint of its secret so that
+// sessions end when the password or token changes
+type session struct {
+	Kind    string `json:"k"`
+	Name    string `json:"n"`
+	Secret  string `json:"s"`
+	Expires int64  `json:"e"`
+}
+
+func NewAuth(cfg AuthConfig) *Auth {
+	a := &Auth{limiter: newFailureLimiter(authMaxFailures, authFailureWindow)}
+	a.SetConfig(cfg)
+	return a
+}
+
+// Replace the configuration, e.g. to revoke a kiosk. Without a session key,
+// a random one is used and sessions do not survive a restart.
+func (a *Auth) SetConfig(cfg AuthConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case cfg.SessionKey != "":
+		a.key = []byte(cfg.SessionKey)
+	case a.key == nil || a.cfg.SessionKey != "":
+		a.key = make([]byte, 32)
+		rand.Read(a.key)
+		if authEnabled(cfg) {
+			log.Printf("[auth] no session key configured, sessions end on restart")
+		}
+	}
+	a.cfg = cfg
+}
+
+func authEnabled(cfg AuthConfig) bool {
+	return len(cfg.Users) > 0 || len(cfg.Tokens) > 0 || len(cfg.Kiosks) > 0
+}
+
+// Paths served without authentication. The sensor push endpoint has its
+// own tokens.
+func publicPath(path string) bool {
+	return path == "/health" || strings.HasPrefix(path, "/health/") ||
+		strings.HasPrefix(path, "/static/") ||
+		path == "/api/temperature/push" ||
+		path == "/login" || path == "/logout"
+}
+
+// Protect a handler: API requests are answered with 401, page requests are
+// redirected to the login form
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		enabled := authEnabled(a.cfg)
+		a.mu.RUnlock()
+		if !enabled || publicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only credentials are rate limited, not sessions sharing the address
+		ip := clientIP(r)
+		credentials := r.URL.Query().Has("kiosk") || r.Header.Get("Authorization") != ""
+		if wait := a.limiter.blocked(ip); credentials && wait > 0 {
+			metricAuthRejected.Inc("rate_limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		if method, ok := a.authenticate(w, r); !ok {
+			if method != "" {
+				a.limiter.fail(ip)
+				metricAuthRejected.Inc(method)
+				log.Printf("[auth] rejected %s credentials from %s", method, ip)
+			}
+			a.deny(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Check the credentials of a request, in order: kiosk token (?kiosk=),
+// bearer token, HTTP Basic, session cookie. method names the credentials
+// presented, empty if none.
+func (a *Auth) authenticate(w http.ResponseWriter, r *http.Request) (method string, ok bool) {
+	if token := r.URL.Query().Get("kiosk"); token != "" {
+		kiosk := a.kiosk(token)
+		if kiosk == nil {
+			return "kiosk", false
+		}
+		a.setSession(w, r, session{Kind: "kiosk", Name: kiosk.Name, Secret: a.fingerprint(kiosk.Token)})
+		return "kiosk", true
+	}
+
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return "bearer", a.token(token)
+	}
+	if name, password, found := r.BasicAuth(); found {
+		return "basic", a.user(name, password) != nil
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	s, valid := a.verify(c.Value)
+	if !valid {
+		clearSession(w)
+		return "", false
+	}
+	// Sliding expiry, so that open dashboards and kiosks stay signed in
+	a.mu.RLock()
+	ttl := a.cfg.SessionTTL
+	a.mu.RUnlock()
+	if time.Unix(s.Expires, 0).Sub(clock.Now()) < ttl/2 {
+		a.setSession(w, r, s)
+	}
+	return "session", true
+}
+
+// Reject an unauthenticated request. Without users there is no login form
+// to redirect pages to: they need a kiosk link or a token, like the API.
+func (a *Auth) deny(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	basic := len(a.cfg.Users) > 0
+	a.mu.RUnlock()
+
+	if basic && !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="strasboard"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="strasboard"`)
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// Find a user by name and password
+func (a *Auth) user(name, password string) *UserConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for i, u := range a.cfg.Users {
+		if u.Name == name && subtle.ConstantTimeCompare([]byte(password), []byte(u.Password)) == 1 {
+			return &a.cfg.Users[i]
+		}
+	}
+	return nil
+}
+
+// Check a static API token
+func (a *Auth) token(token string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, t := range a.cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Find a kiosk by token
+func (a *Auth) kiosk(token string) *KioskConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for i, k := range a.cfg.Kiosks {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Token)) == 1 {
+			return &a.cfg.Kiosks[i]
+		}
+	}
+	return nil
+}
+
+// Keyed hash of a password or token, stored in sessions instead of the secret
+func (a *Auth) fingerprint(secret string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return hex.EncodeToString(a.mac([]byte(secret))[:8])
+}
+
+func (a *Auth) mac(data []byte) []byte {
+	m := hmac.New(sha256.New, a.key)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// Issue a signed session cookie
+func (a *Auth) setSession(w http.ResponseWriter, r *http.Request, s session) {
+	a.mu.RLock()
+	ttl := a.cfg.SessionTTL
+	s.Expires = clock.Now().Add(ttl).Unix()
+	payload, _ := json.Marshal(s)
+	value := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(a.mac(payload))
+	a.mu.RUnlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// Check whether a request reached the server or its proxy over HTTPS
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// Issue the token of a login form, also set in a cookie of the login page
+// (double submit), so that other sites cannot post the form to sign a
+// visitor into another account
+func setCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/login",
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// Check that a posted form carries the token of its cookie
+func validCSRFToken(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	token := r.PostFormValue("csrf")
+	return err == nil && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Value)) == 1
+}
+
+// Check the signature and expiry of a session cookie, and that its user or
+// kiosk is still configured with the same secret
+func (a *Auth) verify(value string) (session, bool) {
+	var s session
+	p, sig, found := strings.Cut(value, ".")
+	if !found {
+		return s, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return s, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return s, false
+	}
+
+	a.mu.RLock()
+	valid := hmac.Equal(mac, a.mac(payload))
+	a.mu.RUnlock()
+	if !valid || json.Unmarshal(payload, &s) != nil || clock.Now().Unix() >= s.Expires {
+		return s, false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch s.Kind {
+	case "user":
+		for _, u := range a.cfg.Users {
+			if u.Name == s.Name && hex.EncodeToString(a.mac([]byte(u.Password))[:8]) == s.Secret {
+				return s, true
+			}
+		}
+	case "kiosk":
+		for _, k := range a.cfg.Kiosks {
+			if k.Name == s.Name && hex.EncodeToString(a.mac([]byte(k.Token))[:8]) == s.Secret {
+				return s, true
+			}
+		}
+	}
+	return s, false
+}
+
+// HTTP handler for the login form
+func (a *Auth) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next := r.FormValue("next")
+		if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+			next = "/"
+		}
+
+		a.mu.RLock()
+		enabled := len(a.cfg.Users) > 0
+		a.mu.RUnlock()
+		if !enabled {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		data := struct {
+			Next  string
+			CSRF  string
+			Error string
+		}{Next: next}
+		status := http.StatusOK
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			ip := clientIP(r)
+			if wait := a.limiter.blocked(ip); wait > 0 {
+				metricAuthRejected.Inc("rate_limited")
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				status = http.StatusTooManyRequests
+				data.Error = "Too many failed attempts, try again later."
+				break
+			}
+			if !validCSRFToken(r) {
+				log.Printf("[auth] login form without a valid token from %s", ip)
+				status = http.StatusForbidden
+				data.Error = "The form expired, please sign in again."
+				break
+			}
+			name := r.PostFormValue("username")
+			user := a.user(name, r.PostFormValue("password"))
+			if user == nil {
+				a.limiter.fail(ip)
+				metricAuthRejected.Inc("login")
+				log.Printf("[auth] failed login for %q from %s", name, ip)
+				status = http.StatusUnauthorized
+				data.Error = "Invalid username or password."
+				break
+			}
+			a.limiter.reset(ip)
+			a.setSession(w, r, session{Kind: "user", Name: user.Name, Secret: a.fingerprint(user.Password)})
+			log.Printf("[auth] %s signed in from %s", user.Name, ip)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		tmpl, err := template.ParseFS(templatesFS, "templates/login.html")
+		if err != nil {
+			log.Printf("Template parse error: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.CSRF = setCSRFToken(w, r)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		if err := tmpl.Execute(w, data); err != nil {
+			log.Printf("Template execute error: %v", err)
+		}
+	}
+}
+
+// HTTP handler ending the session, POST only so that links and prefetches
+// do not sign users out
+func (a *Auth) LogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		clearSession(w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSessionKey = "0123456789abcdef0123456789abcdef"
+
+var csrfTokenRe = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+// Server protected by auth, answering "ok" on every page
+func authServer(t *testing.T, cfg AuthConfig) (*Auth, http.Handler) {
+	t.Helper()
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = 24 * time.Hour
+	}
+	a := NewAuth(cfg)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", a.LoginHandler())
+	mux.HandleFunc("/logout", a.LogoutHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return a, a.Middleware(mux)
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func sessionFrom(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie && c.MaxAge > 0 {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie set")
+	return nil
+}
+
+func TestAuthDisabled(t *testing.T) {
+	_, h := authServer(t, AuthConfig{})
+	for _, path := range []string{"/", "/api/all", "/metrics"} {
+		if w := serve(h, httptest.NewRequest("GET", path, nil)); w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", path, w.Code)
+		}
+	}
+}
+
+func TestAuthPublicPaths(t *testing.T) {
+	_, h := authServer(t, AuthConfig{Tokens: []string{"secret"}})
+	for _, path := range []string{"/health", "/health/ready", "/static/app.css", "/api/temperature/push"} {
+		if w := serve(h, httptest.NewRequest("GET", path, nil)); w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", path, w.Code)
+		}
+	}
+}
+
+// Without users, pages have no login form to go to and must not loop
+// between / and /login
+func TestAuthWithoutUsers(t *testing.T) {
+	for name, cfg := range map[string]AuthConfig{
+		"tokens only": {Tokens: []string{"secret"}},
+		"kiosks only": {Kiosks: []KioskConfig{{Name: "hallway", Token: "kiosk-token"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, h := authServer(t, cfg)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			for _, path := range []string{"/", "/login", "/api/all"} {
+				resp, err := srv.Client().Get(srv.URL + path)
+				if err != nil {
+					t.Fatalf("%s: %v", path, err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusUnauthorized {
+					t.Errorf("%s: status %d, want 401", path, resp.StatusCode)
+				}
+				if got := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(got, "Bearer") {
+					t.Errorf("%s: WWW-Authenticate %q, want a Bearer challenge", path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthCredentials(t *testing.T) {
+	cfg := AuthConfig{
+		Users:  []UserConfig{{Name: "alice", Password: "wonderland"}},
+		Tokens: []string{"api-token"},
+		Kiosks: []KioskConfig{{Name: "hallway", Token: "kiosk-token"}},
+	}
+	tests := []struct {
+		name     string
+		path     string
+		setup    func(r *http.Request)
+		status   int
+		location string
+	}{
+		{"page without credentials", "/", nil, http.StatusSeeOther, "/login?next=%2F"},
+		{"page keeps its query", "/?view=tv", nil, http.StatusSeeOther, "/login?next=%2F%3Fview%3Dtv"},
+		{"api without credentials", "/api/all", nil, http.StatusUnauthorized, ""},
+		{"metrics without credentials", "/metrics", nil, http.StatusUnauthorized, ""},
+		{"basic", "/api/all", func(r *http.Request) { r.SetBasicAuth("alice", "wonderland") }, http.StatusOK, ""},
+		{"basic wrong password", "/api/all", func(r *http.Request) { r.SetBasicAuth("alice", "nope") }, http.StatusUnauthorized, ""},
+		{"basic unknown user", "/api/all", func(r *http.Request) { r.SetBasicAuth("bob", "wonderland") }, http.StatusUnauthorized, ""},
+		{"bearer", "/api/all", func(r *http.Request) { r.Header.Set("Authorization", "Bearer api-token") }, http.StatusOK, ""},
+		{"bearer wrong token", "/api/all", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized, ""},
+		{"bearer on a page", "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer api-token") }, http.StatusOK, ""},
+		{"kiosk", "/?kiosk=kiosk-token", nil, http.StatusOK, ""},
+		{"kiosk wrong token", "/?kiosk=nope", nil, http.StatusSeeOther, "/login?next=%2F%3Fkiosk%3Dnope"},
+		{"kiosk token on the api", "/api/all?kiosk=kiosk-token", nil, http.StatusOK, ""},
+		{"invalid cookie", "/api/all", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "garbage"}) }, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := authServer(t, cfg)
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			w := serve(h, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("location %q, want %q", got, tt.location)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="strasboard"` {
+				t.Errorf("WWW-Authenticate %q, want a Basic challenge", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// A kiosk link mints a session cookie that works without the token, until
+// the kiosk is removed
+func TestAuthKioskSession(t *testing.T) {
+	cfg := AuthConfig{SessionKey: testSessionKey, Kiosks: []KioskConfig{{Name: "hallway", Token: "kiosk-token"}}}
+	a, h := authServer(t, cfg)
+
+	w := serve(h, httptest.NewRequest("GET", "/?kiosk=kiosk-token", nil))
+	cookie := sessionFrom(t, w)
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie not HttpOnly and SameSite=Lax: %+v", cookie)
+	}
+
+	r := httptest.NewRequest("GET", "/api/all", nil)
+	r.AddCookie(cookie)
+	if w := serve(h, r); w.Code != http.StatusOK {
+		t.Fatalf("with kiosk session: status %d, want 200", w.Code)
+	}
+
+	cfg.Kiosks = []KioskConfig{{Name: "hallway", Token: "new-token"}}
+	a.SetConfig(cfg)
+	r = httptest.NewRequest("GET", "/api/all", nil)
+	r.AddCookie(cookie)
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("after token change: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthLogin(t *testing.T) {
+	cfg := AuthConfig{SessionKey: testSessionKey, Users: []UserConfig{{Name: "alice", Password: "wonderland"}}}
+	// Post the form with the token and cookie of a freshly loaded one
+	login := func(h http.Handler, password, next string) *httptest.ResponseRecorder {
+		w := serve(h, httptest.NewRequest("GET", "/login", nil))
+		token := csrfTokenRe.FindStringSubmatch(w.Body.String())
+		cookies := w.Result().Cookies()
+		if token == nil || len(cookies) != 1 || cookies[0].Value != token[1] {
+			t.Fatalf("login form without a token and its cookie: %v", cookies)
+		}
+		form := url.Values{"username": {"alice"}, "password": {password}, "next": {next}, "csrf": {token[1]}}
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookies[0])
+		return serve(h, r)
+	}
+
+	t.Run("form", func(t *testing.T) {
+		_, h := authServer(t, cfg)
+		if w := serve(h, httptest.NewRequest("GET", "/login?next=%2F", nil)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<form") {
+			t.Errorf("login form: status %d", w.Code)
+		}
+	})
+
+	// Forms posted from elsewhere lack the token of the cookie
+	t.Run("csrf", func(t *testing.T) {
+		_, h := authServer(t, cfg)
+		for name, cookie := range map[string]string{"no cookie": "", "other cookie": "other-token"} {
+			form := url.Values{"username": {"alice"}, "password": {"wonderland"}, "csrf": {"form-token"}}
+			r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: cookie})
+			}
+			w := serve(h, r)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s: status %d, want 403", name, w.Code)
+			}
+			for _, c := range w.Result().Cookies() {
+				if c.Name == sessionCookie {
+					t.Errorf("%s: session issued", name)
+				}
+			}
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, h := authServer(t, cfg)
+		w := login(h, "nope", "/")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", w.Code)
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookie {
+				t.Errorf("session issued")
+			}
+		}
+	})
+
+	// Only local paths are followed after login
+	for next, want := range map[string]string{
+		"/?view=tv":           "/?view=tv",
+		"/api/all":            "/api/all",
+		"":                    "/",
+		"https://evil.test/":  "/",
+		"//evil.test/":        "/",
+		"/\\evil.test/":       "/",
+		"javascript:alert(1)": "/",
+	} {
+		t.Run("next "+next, func(t *testing.T) {
+			_, h := authServer(t, cfg)
+			w := login(h, "wonderland", next)
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("status %d, want 303", w.Code)
+			}
+			if got := w.Header().Get("Location"); got != want {
+				t.Errorf("location %q, want %q", got, want)
+			}
+			sessionFrom(t, w)
+		})
+	}
+
+	t.Run("logout", func(t *testing.T) {
+		_, h := authServer(t, cfg)
+		if w := serve(h, httptest.NewRequest("GET", "/logout", nil)); w.Code != http.StatusMethodNotAllowed || len(w.Result().Cookies()) != 0 {
+			t.Errorf("GET: status %d, want 405 keeping the session", w.Code)
+		}
+		w := serve(h, httptest.NewRequest("POST", "/logout", nil))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+			t.Errorf("status %d to %q, want 303 to /login", w.Code, w.Header().Get("Location"))
+		}
+		if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+			t.Errorf("cookie not cleared: %v", c)
+		}
+	})
+}
+
+// Session cookies are signed, expire, slide while in use, and end when the
+// password changes
+func TestAuthSession(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	cfg := AuthConfig{SessionKey: testSessionKey, SessionTTL: 24 * time.Hour, Users: []UserConfig{{Name: "alice", Password: "wonderland"}}}
+	a, h := authServer(t, cfg)
+
+	issue := func() *http.Cookie {
+		w := httptest.NewRecorder()
+		a.setSession(w, httptest.NewRequest("GET", "/", nil), session{Kind: "user", Name: "alice", Secret: a.fingerprint("wonderland")})
+		return sessionFrom(t, w)
+	}
+	get := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/all", nil)
+		r.AddCookie(cookie)
+		return serve(h, r)
+	}
+
+	cookie := issue()
+	if w := get(cookie); w.Code != http.StatusOK {
+		t.Fatalf("valid session: status %d", w.Code)
+	}
+	if len(get(cookie).Result().Cookies()) != 0 {
+		t.Errorf("fresh session renewed")
+	}
+
+	payload, sig, _ := strings.Cut(cookie.Value, ".")
+	forged := *cookie
+	forged.Value = payload + "." + strings.Repeat("A", len(sig))
+	if w := get(&forged); w.Code != http.StatusUnauthorized {
+		t.Errorf("forged signature: status %d, want 401", w.Code)
+	}
+
+	// Another key does not verify the cookie
+	other := NewAuth(AuthConfig{SessionKey: strings.Repeat("x", 32), SessionTTL: 24 * time.Hour, Users: cfg.Users})
+	if _, ok := other.verify(cookie.Value); ok {
+		t.Errorf("cookie verified with another key")
+	}
+
+	// Past half its lifetime, a session is renewed
+	c.Advance(13 * time.Hour)
+	w := get(cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("session after 13h: status %d", w.Code)
+	}
+	renewed := sessionFrom(t, w)
+
+	c.Advance(12 * time.Hour)
+	if w := get(cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired session: status %d, want 401", w.Code)
+	}
+	if w := get(renewed); w.Code != http.StatusOK {
+		t.Errorf("renewed session: status %d, want 200", w.Code)
+	}
+
+	cfg.Users[0].Password = "changed"
+	a.SetConfig(cfg)
+	if w := get(renewed); w.Code != http.StatusUnauthorized {
+		t.Errorf("after password change: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	_, h := authServer(t, AuthConfig{Tokens: []string{"api-token"}})
+	bearer := func(token string) int {
+		r := httptest.NewRequest("GET", "/api/all", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return serve(h, r).Code
+	}
+	for i := 0; i < authMaxFailures; i++ {
+		if code := bearer("nope"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, code)
+		}
+	}
+	if code := bearer("api-token"); code != http.StatusTooManyRequests {
+		t.Errorf("after %d failures: status %d, want 429", authMaxFailures, code)
+	}
+
+	// Clients without credentials are not counted nor blocked
+	if w := serve(h, httptest.NewRequest("GET", "/api/all", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	breakerThreshold   = 5
+	breakerCooldown    = 1 * time.Minute
+	breakerMaxCooldown = 30 * time.Minute
+	errorRetryBase     = 30 * time.Second
+	errorRetryMax      = 10 * time.Minute
+)
+
+// Circuit breakers for upstream hosts
+var breakers = &breakerSet{hosts: make(map[string]*circuitBreaker)}
+
+type breakerSet struct {
+	mu    sync.Mutex
+	hosts map[string]*circuitBreaker
+}
+
+// Get or create the breaker of a host
+func (b *breakerSet) get(host string) *circuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb, ok := b.hosts[host]
+	if !ok {
+		cb = &circuitBreaker{host: host, cooldown: breakerCooldown}
+		b.hosts[host] = cb
+	}
+	return cb
+}
+
+// circuitBreaker stops calling a host after repeated failures. Once the
+// cooldown has elapsed, a single trial request is let through: success
+// closes the circuit, failure opens it again for twice as long.
+type circuitBreaker struct {
+	host string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	cooldown  time.Duration
+	probing   bool
+}
+
+// Error returned while the circuit of a host is open
+type CircuitOpenError struct {
+	Host    string
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s (retry in %s)", e.Host, e.RetryAt.Sub(clock.Now()).Round(time.Second))
+}
+
+// Error from a request to an upstream host
+type upstreamError struct {
+	host string
+	err  error
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+func (e *upstreamError) Unwrap() error { return e.err }
+
+// Check whether a request may be sent
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < breakerThreshold {
+		return nil
+	}
+	if clock.Now().Before(cb.openUntil) || cb.probing {
+		retryAt := cb.openUntil
+		if cb.probing {
+			retryAt = clock.Now().Add(errorRetryBase)
+		}
+		return &CircuitOpenError{Host: cb.host, RetryAt: retryAt}
+	}
+	cb.probing = true
+	return nil
+}
+
+// Record a successful request
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+	cb.probing = false
+	cb.openUntil = time.Time{}
+	cb.cooldown = breakerCooldown
+}
+
+// Record a failed request, opening the circuit past the threshold
+func (cb *circuitBreaker) failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.failures < breakerThreshold {
+		return
+	}
+	if cb.probing || cb.failures == breakerThreshold {
+		cb.openUntil = clock.Now().Add(cb.cooldown)
+		cb.cooldown = min(cb.cooldown*2, breakerMaxCooldown)
+	}
+	cb.probing = false
+}
+
+// Forget an unfinished trial request
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+}
+
+// Delay before a source should retry after a failure on this host
+func (cb *circuitBreaker) retryDelay() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if d := cb.openUntil.Sub(clock.Now()); d > 0 {
+		return d
+	}
+	delay := errorRetryBase
+	for i := 1; i < cb.failures && delay < errorRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, errorRetryMax)
+}
+
+// Error TTL for a failed fetch: follows the breaker state of the failing
+// host for upstream errors, or the fallback for other errors
+func errorTTL(err error, fallback time.Duration) time.Duration {
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return max(open.RetryAt.Sub(clock.Now()), time.Second)
+	}
+	var upstream *upstreamError
+	if errors.As(err, &upstream) {
+		return breakers.get(upstream.host).retryDelay()
+	}
+	return fallback
+}
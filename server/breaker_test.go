@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Failures open the circuit, the cooldown lets a single trial through, and
+// a failed trial doubles the cooldown
+func TestCircuitBreaker(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	cb := &circuitBreaker{host: "api.example", cooldown: breakerCooldown}
+
+	for i := 0; i < breakerThreshold; i++ {
+		if err := cb.allow(); err != nil {
+			t.Fatalf("closed circuit, failure %d: %v", i, err)
+		}
+		cb.failure()
+	}
+	var open *CircuitOpenError
+	if err := cb.allow(); !errors.As(err, &open) || !open.RetryAt.Equal(cb.openUntil) {
+		t.Fatalf("after %d failures: %v, want an open circuit", breakerThreshold, err)
+	}
+	if d := cb.retryDelay(); d <= breakerCooldown-time.Second || d > breakerCooldown {
+		t.Errorf("retry delay %s while open, want the cooldown", d)
+	}
+
+	// Half-open: one trial at a time
+	c.Advance(breakerCooldown)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("after cooldown: %v, want a trial", err)
+	}
+	if err := cb.allow(); !errors.As(err, &open) {
+		t.Errorf("during the trial: %v, want an open circuit", err)
+	}
+	cb.failure()
+	c.Advance(breakerCooldown)
+	if err := cb.allow(); err == nil {
+		t.Error("failed trial: circuit closed after the first cooldown")
+	}
+	c.Advance(breakerCooldown)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("failed trial: %v after twice the cooldown", err)
+	}
+
+	// A cancelled trial lets the next caller try
+	cb.release()
+	if err := cb.allow(); err != nil {
+		t.Errorf("after a cancelled trial: %v", err)
+	}
+	cb.success()
+	if err := cb.allow(); err != nil || cb.cooldown != breakerCooldown {
+		t.Errorf("after a successful trial: %v, cooldown %s", err, cb.cooldown)
+	}
+}
+
+func TestErrorTTL(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	host := "errorttl.example"
+	cb := breakers.get(host)
+	upstream := &upstreamError{host: host, err: errors.New("server returned 502")}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, errorRetryBase},
+		{2, 2 * errorRetryBase},
+		{4, 8 * errorRetryBase},
+	}
+	for _, tt := range tests {
+		cb.success()
+		for i := 0; i < tt.failures; i++ {
+			cb.failure()
+		}
+		if got := errorTTL(upstream, time.Hour); got != tt.want {
+			t.Errorf("%d failures: %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// Open circuit: retry once it closes, at least a second from now
+	cb.failure()
+	if got := errorTTL(upstream, time.Hour); got <= breakerCooldown-time.Second || got > breakerCooldown {
+		t.Errorf("open circuit: %s, want the cooldown", got)
+	}
+	err := cb.allow()
+	c.Advance(breakerCooldown)
+	if got := errorTTL(err, time.Hour); got != time.Second {
+		t.Errorf("circuit open error past its retry time: %s, want 1s", got)
+	}
+
+	if got := errorTTL(errors.New("failed to decode response"), time.Hour); got != time.Hour {
+		t.Errorf("other error: %s, want the fallback", got)
+	}
+	cb.success()
+}
+
+// Idempotent requests are retried on server errors, up to the attempt limit,
+// and the whole request counts as one breaker failure
+func TestRequestRetries(t *testing.T) {
+	var hits, fail atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= fail.Load() {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		method  string
+		fail    int32
+		hits    int32
+		success bool
+	}{
+		{"GET recovering", http.MethodGet, httpMaxAttempts - 1, httpMaxAttempts, true},
+		{"GET failing", http.MethodGet, httpMaxAttempts + 1, httpMaxAttempts, false},
+		{"POST failing", http.MethodPost, 1, 1, false},
+	}
+	for _, tt := range tests {
+		hits.Store(0)
+		fail.Store(tt.fail)
+		breakers.get(host).success()
+
+		var dest struct{ OK bool }
+		var err error
+		if tt.method == http.MethodGet {
+			_, err = GetJSON(ctx, srv.URL, nil, nil, nil, &dest, nil)
+		} else {
+			_, err = PostForm(ctx, srv.URL, url.Values{}, nil, nil, &dest, nil)
+		}
+		if n := hits.Load(); n != tt.hits {
+			t.Errorf("%s: %d requests, want %d", tt.name, n, tt.hits)
+		}
+		if (err == nil) != tt.success || dest.OK != tt.success {
+			t.Errorf("%s: %v, decoded %v", tt.name, err, dest.OK)
+		}
+		want := 1
+		if tt.success {
+			want = 0
+		}
+		if n := breakers.get(host).failures; n != want {
+			t.Errorf("%s: %d breaker failures, want %d", tt.name, n, want)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -8,8 +14,22 @@ import (
 type Cache struct {
 	mu    sync.RWMutex
 	items map[string]cacheItem
+
+	// Concurrent refreshes of the same key share one fetch
+	inflight flightGroup[string, *Response]
+
+	// Optional on-disk snapshot written after every Set
+	saveMu sync.Mutex
+	path   string
+
+	// Callbacks run after every Set
+	listeners []CacheListener
 }
 
+// CacheListener is notified after a response is stored, with the
+// previously stored response (nil if none).
+type CacheListener func(key string, prev, resp *Response)
+
 type cacheItem struct {
 	data   *Response
 	backup *Response
@@ -25,19 +45,29 @@ func (c *Cache) Get(key string) *Response {
 	defer c.mu.RUnlock()
 
 	item, ok := c.items[key]
-	if !ok || item.data == nil || time.Now().After(item.data.ExpiresAt) {
+	if !ok || item.data == nil || clock.Now().After(item.data.ExpiresAt) {
+		metricCacheMisses.Inc(key)
 		return nil
 	}
+	metricCacheHits.Inc(key)
 	return item.data
 }
 
+// Get latest response regardless of expiration
+func (c *Cache) Peek(key string) *Response {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.items[key].data
+}
+
 // Get backup response for degraded mode
 func (c *Cache) GetBackup(key string) *Response {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	item, ok := c.items[key]
-	if !ok || item.backup == nil || time.Now().After(item.backup.ExpiresAt) {
+	if !ok || item.backup == nil || clock.Now().After(item.backup.ExpiresAt) {
 		return nil
 	}
 	return item.backup
@@ -46,9 +76,8 @@ func (c *Cache) GetBackup(key string) *Response {
 // Store response and update backup if successful
 func (c *Cache) Set(key string, resp *Response, degradedTTL time.Duration) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	item := c.items[key]
+	prev := item.data
 	item.data = resp
 
 	if resp.Error == "" {
@@ -56,4 +85,187 @@ func (c *Cache) Set(key string, resp *Response, degradedTTL time.Duration) {
 	}
 
 	c.items[key] = item
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	if err := c.save(); err != nil {
+		log.Printf("[cache] save: %v", err)
+	}
+	for _, fn := range listeners {
+		fn(key, prev, resp)
+	}
+}
+
+// Register a callback run after every Set
+func (c *Cache) OnSet(fn CacheListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listeners = append(c.listeners, fn)
+}
+
+// Check whether two responses carry the same data and error
+func sameResponse(a, b *Response) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Error != b.Error {
+		return false
+	}
+	da, errA := json.Marshal(a.Data)
+	db, errB := json.Marshal(b.Data)
+	return errA == nil && errB == nil && bytes.Equal(da, db)
+}
+
+// Write the snapshot to disk, if enabled
+func (c *Cache) Flush() error {
+	return c.save()
+}
+
+// Snapshot entry as stored on disk
+type snapshotItem struct {
+	Data   *snapshotResponse `json:"data,omitempty"`
+	Backup *snapshotResponse `json:"backup,omitempty"`
+}
+
+type snapshotResponse struct {
+	Data        json.RawMessage `json:"data,omitempty"`
+	Timestamp   string          `json:"timestamp"`
+	Error       string          `json:"error,omitempty"`
+	Stale       bool            `json:"stale,omitempty"`
+	LastAttempt string          `json:"last_attempt,omitempty"`
+	LastErrorAt string          `json:"last_error_at,omitempty"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+}
+
+// Enable the on-disk snapshot and reload entries that have not expired yet
+func (c *Cache) Persist(path string) error {
+	c.path = path
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var items map[string]snapshotItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := clock.Now()
+	for key, si := range items {
+		item := cacheItem{
+			data:   si.Data.restore(now),
+			backup: si.Backup.restore(now),
+		}
+		if item.data != nil || item.backup != nil {
+			c.items[key] = item
+		}
+	}
+	log.Printf("[cache] restored %d entries from %s", len(c.items), path)
+	return nil
+}
+
+// Write all entries to disk atomically
+func (c *Cache) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.RLock()
+	items := make(map[string]snapshotItem, len(c.items))
+	var err error
+	for key, item := range c.items {
+		var si snapshotItem
+		if si.Data, err = newSnapshotResponse(item.data); err != nil {
+			break
+		}
+		if si.Backup, err = newSnapshotResponse(item.backup); err != nil {
+			break
+		}
+		items[key] = si
+	}
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	return writeFileAtomic(c.path, b)
+}
+
+// Convert a response to its snapshot form
+func newSnapshotResponse(resp *Response) (*snapshotResponse, error) {
+	if resp == nil {
+		return nil, nil
+	}
+	sr := &snapshotResponse{
+		Timestamp:   resp.Timestamp,
+		Error:       resp.Error,
+		Stale:       resp.Stale,
+		LastAttempt: resp.LastAttempt,
+		LastErrorAt: resp.LastErrorAt,
+		ExpiresAt:   resp.ExpiresAt,
+	}
+	if resp.Data != nil {
+		data, err := json.Marshal(resp.Data)
+		if err != nil {
+			return nil, fmt.Errorf("encode data: %w", err)
+		}
+		sr.Data = data
+	}
+	return sr, nil
+}
+
+// Convert a snapshot back to a response, dropping it if expired
+func (sr *snapshotResponse) restore(now time.Time) *Response {
+	if sr == nil || now.After(sr.ExpiresAt) {
+		return nil
+	}
+	resp := &Response{
+		Timestamp:   sr.Timestamp,
+		Error:       sr.Error,
+		Stale:       sr.Stale,
+		LastAttempt: sr.LastAttempt,
+		LastErrorAt: sr.LastErrorAt,
+		ExpiresAt:   sr.ExpiresAt,
+	}
+	if len(sr.Data) > 0 {
+		resp.Data = sr.Data
+	}
+	return resp
+}
+
+// Write a file through a temporary file and rename
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Entries survive a restart through the snapshot, expired ones excepted,
+// and their data decodes back from raw JSON
+func TestCachePersist(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	tempo := TempoData{{Date: "2026-01-15", Color: "red"}, {Date: "2026-01-16", Color: "white"}}
+	cache := NewCache()
+	if err := cache.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("tempo", NewResponse(tempo, time.Hour), 24*time.Hour)
+	cache.Set("weather", NewResponse(WeatherData{}, time.Minute), 2*time.Minute)
+	cache.Set("transport", NewResponse("departures", time.Minute), time.Hour)
+	cache.Set("transport", ErrorResponse("upstream down", time.Hour), time.Hour)
+	want := cache.Peek("tempo")
+
+	c.Advance(5 * time.Minute)
+	restored := NewCache()
+	if err := restored.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got := restored.Get("tempo")
+	if got == nil {
+		t.Fatal("tempo not restored")
+	}
+	if _, raw := got.Data.(json.RawMessage); !raw {
+		t.Errorf("restored data is %T, want raw JSON", got.Data)
+	}
+	if data, ok := decodeValue[TempoData](got.Data); !ok || !reflect.DeepEqual(data, tempo) {
+		t.Errorf("decoded %v, %v, want %v", data, ok, tempo)
+	}
+	if got.Timestamp != want.Timestamp || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("restored at %s expiring %s, want %s and %s", got.Timestamp, got.ExpiresAt, want.Timestamp, want.ExpiresAt)
+	}
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("restored encodes as %s, want %s", gotJSON, wantJSON)
+	}
+
+	if restored.Peek("weather") != nil || restored.GetBackup("weather") != nil {
+		t.Error("expired weather restored")
+	}
+
+	if resp := restored.Peek("transport"); resp == nil || resp.Error != "upstream down" {
+		t.Errorf("transport response %+v, want the error", resp)
+	}
+	if backup := restored.GetBackup("transport"); backup == nil || string(backup.Data.(json.RawMessage)) != `"departures"` {
+		t.Errorf("transport backup %+v, want the last data", backup)
+	}
+}
+
+func TestCachePersistCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCache()
+	if err := cache.Persist(path); err == nil {
+		t.Error("corrupt snapshot accepted")
+	}
+
+	// The next Set replaces it
+	cache.Set("tempo", NewResponse(TempoData{}, time.Hour), time.Hour)
+	if err := NewCache().Persist(path); err != nil {
+		t.Errorf("snapshot after Set: %v", err)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	if _, ok := decodeValue[TempoData](nil); ok {
+		t.Error("nil decoded")
+	}
+	if _, ok := decodeValue[TempoData](json.RawMessage(`{"date": 1}`)); ok {
+		t.Error("mismatched JSON decoded")
+	}
+	data := TempoData{{Date: "2026-01-15", Color: "blue"}}
+	if got, ok := decodeValue[TempoData](data); !ok || !reflect.DeepEqual(got, data) {
+		t.Errorf("typed value: %v, %v", got, ok)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Colour names used by EDF in event titles
+var tempoColorNames = map[string]string{
+	"blue":  "BLEU",
+	"white": "BLANC",
+	"red":   "ROUGE",
+}
+
+// Build an RFC 5545 calendar with one all-day event per Tempo day. UIDs
+// only depend on the date, so that calendar apps update a colour in place.
+// With a non-zero alarm, red days get a reminder at that time of day on the
+// evening before.
+func tempoCalendar(data TempoData, stamp time.Time, alarm time.Duration) []byte {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	dtstamp := stamp.UTC().Format("20060102T150405Z")
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//StrasBoard//Tempo//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:Tempo")
+	line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	line("X-PUBLISHED-TTL:PT1H")
+
+	for _, day := range data {
+		date, err := time.Parse(time.DateOnly, day.Date)
+		if err != nil {
+			continue
+		}
+		name := tempoColorNames[day.Color]
+		if name == "" {
+			name = strings.ToUpper(day.Color)
+		}
+
+		line("BEGIN:VEVENT")
+		line("UID:tempo-%s@strasboard", day.Date)
+		line("DTSTAMP:%s", dtstamp)
+		line("LAST-MODIFIED:%s", dtstamp)
+		line("DTSTART;VALUE=DATE:%s", date.Format("20060102"))
+		line("DTEND;VALUE=DATE:%s", date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:Tempo %s", name)
+		line("TRANSP:TRANSPARENT")
+		if tempoColorNames[day.Color] != "" {
+			line("COLOR:%s", day.Color)
+		}
+		if alarm > 0 && day.Color == "red" {
+			line("BEGIN:VALARM")
+			line("ACTION:DISPLAY")
+			line("DESCRIPTION:Tempo %s tomorrow", name)
+			line("TRIGGER:%s", icalDuration(alarm-24*time.Hour))
+			line("END:VALARM")
+		}
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// Format a duration of whole minutes, e.g. -PT4H30M
+func icalDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	s := sign + "PT"
+	if h := int(d.Hours()); h > 0 {
+		s += fmt.Sprintf("%dH", h)
+	}
+	if m := int(d.Minutes()) % 60; m > 0 || d < time.Hour {
+		s += fmt.Sprintf("%dM", m)
+	}
+	return s
+}
+
+// HTTP handler serving the Tempo calendar of the current season, from
+// September 1 to the latest published day. ?alarm=HH:MM adds reminders
+// before red days.
+func tempoCalendarHandler(sources *SourceSet, cache *Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		src, ok := sources.Get("tempo").(*TempoSource)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		var alarm time.Duration
+		if v := r.URL.Query().Get("alarm"); v != "" {
+			t, err := time.Parse("15:04", v)
+			if err != nil {
+				http.Error(w, "invalid alarm, expected HH:MM", http.StatusBadRequest)
+				return
+			}
+			alarm = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+			if alarm == 0 {
+				// 00:00 ends the evening before
+				alarm = 24 * time.Hour
+			}
+		}
+
+		// Stored days are still right, being dated: serve them while failing
+		resp := fetchCached(r.Context(), cache, src)
+		data := src.Season()
+		if len(data) == 0 {
+			// Memory-only store, filled by the next refresh
+			data, _ = decodeValue[TempoData](resp.Data)
+		}
+		if len(data) == 0 {
+			// Keep subscribers from dropping their events
+			w.Header().Set("Retry-After", "600")
+			http.Error(w, "tempo data unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		meta := responseMeta(&Response{Data: data, Timestamp: resp.Timestamp, ExpiresAt: resp.ExpiresAt})
+		if writeCacheHeaders(w, r, meta) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="tempo.ics"`)
+		w.Write(tempoCalendar(data, meta.modified, alarm))
+	}
+}
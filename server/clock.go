@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time used by the cache, responses and sources, so that
+// TTLs, refresh windows, circuit breaker cooldowns, rate limits and day
+// boundaries can be driven by a fake clock. Network timings (latency,
+// retries) use real time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+var clock Clock = systemClock{}
+
+// Replace the clock, before any source is created
+func SetClock(c Clock) {
+	clock = c
+}
+
+// FakeClock starts at a chosen time and runs at real speed. Set and
+// Advance jump to another time, e.g. just before a DST transition.
+type FakeClock struct {
+	mu    sync.Mutex
+	at    time.Time // fake time at the last jump
+	since time.Time // real time of the last jump
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{at: start, since: time.Now()}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.at.Add(time.Since(c.since))
+}
+
+// Jump to a given time
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.at, c.since = t, time.Now()
+}
+
+// Jump forward or backward by a duration
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
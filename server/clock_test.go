@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func paris(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	return loc
+}
+
+// Install a fake clock for the duration of a test
+func fakeClock(t *testing.T, start time.Time) *FakeClock {
+	t.Helper()
+	c := NewFakeClock(start)
+	SetClock(c)
+	t.Cleanup(func() { SetClock(systemClock{}) })
+	return c
+}
+
+func mustTime(t *testing.T, v string) time.Time {
+	t.Helper()
+	at, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+// Check an expiry, within a second for TTLs relative to the running clock
+func checkExpiry(t *testing.T, resp *Response, want time.Time) {
+	t.Helper()
+	if d := resp.ExpiresAt.Sub(want); d < 0 || d > time.Second {
+		t.Errorf("expires at %s, want %s", resp.ExpiresAt.Format(time.RFC3339), want.Format(time.RFC3339))
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	loc := paris(t)
+	c := fakeClock(t, mustTime(t, "2026-03-29T01:59:00+01:00"))
+
+	if got := clock.Now(); got.Sub(mustTime(t, "2026-03-29T01:59:00+01:00")) > time.Second {
+		t.Errorf("now %s, want the start time", got)
+	}
+
+	// Spring forward: 02:00 local does not exist
+	c.Advance(time.Minute)
+	if got := clock.Now().In(loc).Format("15:04 -07:00"); got != "03:00 +02:00" {
+		t.Errorf("after advance %s, want 03:00 +02:00", got)
+	}
+
+	c.Set(mustTime(t, "2026-10-25T02:30:00+02:00"))
+	c.Advance(time.Hour)
+	if got := clock.Now().In(loc).Format("15:04 -07:00"); got != "02:30 +01:00" {
+		t.Errorf("after fall back %s, want 02:30 +01:00", got)
+	}
+
+	c.Advance(-24 * time.Hour)
+	if got := clock.Now().In(loc).Format("2006-01-02 15:04"); got != "2026-10-24 03:30" {
+		t.Errorf("after going back %s, want 2026-10-24 03:30", got)
+	}
+}
+
+func TestTempoExpiry(t *testing.T) {
+	s := &TempoSource{loc: paris(t)}
+	tests := []struct {
+		name, now, want string
+	}{
+		{"before provisional colour", "2026-01-15T07:30:00+01:00", "2026-01-15T08:00:00+01:00"},
+		{"between 08:00 and 11:00, retry", "2026-01-15T09:00:00+01:00", "2026-01-15T10:00:00+01:00"},
+		{"after definitive colour", "2026-01-15T11:30:00+01:00", "2026-01-16T08:00:00+01:00"},
+		{"over spring forward", "2026-03-28T12:00:00+01:00", "2026-03-29T08:00:00+02:00"},
+		{"over fall back", "2026-10-24T12:00:00+02:00", "2026-10-25T08:00:00+01:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock(t, mustTime(t, tt.now))
+			checkExpiry(t, s.response(TempoData{}), mustTime(t, tt.want))
+		})
+	}
+}
+
+func TestElectricityExpiry(t *testing.T) {
+	s := &ElectricitySource{loc: paris(t)}
+	day := func(date string) *ElectricityData {
+		return &ElectricityData{Days: []Consumption{{Date: date}}}
+	}
+	tests := []struct {
+		name string
+		now  string
+		data *ElectricityData
+		want string
+	}{
+		{"before 01:00", "2026-01-15T00:30:00+01:00", day("2026-01-13"), "2026-01-15T01:00:00+01:00"},
+		{"yesterday available", "2026-01-15T09:00:00+01:00", day("2026-01-14"), "2026-01-16T01:00:00+01:00"},
+		{"yesterday missing, retry", "2026-01-15T09:00:00+01:00", day("2026-01-13"), "2026-01-15T10:00:00+01:00"},
+		{"no data, retry", "2026-01-15T09:00:00+01:00", &ElectricityData{}, "2026-01-15T10:00:00+01:00"},
+		{"over spring forward", "2026-03-28T09:00:00+01:00", day("2026-03-27"), "2026-03-29T01:00:00+01:00"},
+		{"on fall back day", "2026-10-25T09:00:00+01:00", day("2026-10-24"), "2026-10-26T01:00:00+01:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock(t, mustTime(t, tt.now))
+			checkExpiry(t, s.response(tt.data), mustTime(t, tt.want))
+		})
+	}
+}
+
+func TestWeatherExpiry(t *testing.T) {
+	s := &WeatherSource{loc: paris(t)}
+	tests := []struct {
+		name, now, want string
+	}{
+		{"daytime", "2026-01-15T12:00:00+01:00", "2026-01-15T12:15:00+01:00"},
+		{"cut at midnight", "2026-01-15T23:50:00+01:00", "2026-01-16T00:00:00+01:00"},
+		{"just after midnight", "2026-01-16T00:00:00+01:00", "2026-01-16T00:15:00+01:00"},
+		{"midnight before spring forward", "2026-03-28T23:55:00+01:00", "2026-03-29T00:00:00+01:00"},
+		{"over spring forward", "2026-03-29T01:55:00+01:00", "2026-03-29T03:10:00+02:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock(t, mustTime(t, tt.now))
+			checkExpiry(t, s.response(WeatherData{}), mustTime(t, tt.want))
+		})
+	}
+}
+
+// Hourly forecasts keep 4 hours back and end at midnight in 4 days, moving
+// forward with the clock
+func TestWeatherFilterHourly(t *testing.T) {
+	loc := paris(t)
+	s := &WeatherSource{loc: loc}
+	start := time.Date(2026, 3, 27, 0, 0, 0, 0, loc)
+	var hours []WeatherHour
+	for h := start; h.Before(start.AddDate(0, 0, 8)); h = h.Add(time.Hour) {
+		hours = append(hours, WeatherHour{Time: h.Format("2006-01-02T15:04")})
+	}
+	s.hourly = &weatherCache[[]WeatherHour]{data: hours}
+
+	c := fakeClock(t, mustTime(t, "2026-03-28T23:30:00+01:00"))
+	got := s.filterHourly()
+	if first, last := got[0].Time, got[len(got)-1].Time; first != "2026-03-28T20:00" || last != "2026-04-01T00:00" {
+		t.Errorf("before midnight: %s to %s", first, last)
+	}
+
+	c.Advance(time.Hour)
+	got = s.filterHourly()
+	if first, last := got[0].Time, got[len(got)-1].Time; first != "2026-03-28T21:00" || last != "2026-04-02T00:00" {
+		t.Errorf("after midnight: %s to %s", first, last)
+	}
+}
@@ -0,0 +1,43 @@
+// Command fakeupstream emulates the upstream APIs used by the StrasBoard
+// server (Open-Meteo, CTS SIRI, RTE Tempo and SER), with scriptable
+// failure scenarios for end-to-end testing without network or credentials.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"strasboard/server/internal/fakeupstream"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	scenario := flag.String("scenario", "", "comma-separated list of active scenarios")
+	delay := flag.Duration("delay", 30*time.Second, "response delay of the slow scenario")
+	tokenTTL := flag.Duration("token-ttl", time.Minute, "server-side token lifetime of the ser-token-expiry scenario")
+	start := flag.String("start", "", "start the clock at an RFC 3339 time, as the server's CLOCK_START")
+	contract := flag.String("contract", "hchp", "SER contract: base (BASE), hchp (HC/HP) or tempo (HC/HP per Tempo colour)")
+	flag.Parse()
+
+	opts := fakeupstream.Options{Delay: *delay, TokenTTL: *tokenTTL, Contract: *contract}
+	if *start != "" {
+		t, err := time.Parse(time.RFC3339, *start)
+		if err != nil {
+			log.Fatalf("invalid start time: %v", err)
+		}
+		opts.Start = t
+	}
+	s, err := fakeupstream.New(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if unknown := s.SetScenarios(*scenario); len(unknown) > 0 {
+		log.Fatalf("unknown scenarios: %s", strings.Join(unknown, ", "))
+	}
+
+	log.Printf("fake upstream listening on %s (scenarios: %v)", *addr, s.Scenarios())
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tariff codes priced by each offer type
+var offerTariffCodes = map[string][]string{
+	"base":  {"BASE"},
+	"hchp":  {"HC", "HP"},
+	"tempo": {"BUHC", "BUHP", "BCHC", "BCHP", "RHC", "RHP"},
+}
+
+// Consumption re-priced under the contract and alternative offers, over
+// the days that all of them can price
+type Comparison struct {
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Days        int               `json:"days"`
+	SkippedDays int               `json:"skipped_days"`
+	Current     *OfferComparison  `json:"current,omitempty"`
+	Offers      []OfferComparison `json:"offers"`
+}
+
+// Cost of an offer, with its difference to the contract when prices are
+// configured, over the whole range, per year and per month
+type OfferComparison struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type,omitempty"`
+	Total      float64      `json:"total"`
+	Difference *float64     `json:"difference,omitempty"`
+	Years      []PeriodCost `json:"years"`
+	Months     []PeriodCost `json:"months"`
+	Error      string       `json:"error,omitempty"`
+}
+
+type PeriodCost struct {
+	Period     string   `json:"period"`
+	Days       int      `json:"days"`
+	Total      float64  `json:"total"`
+	Difference *float64 `json:"difference,omitempty"`
+}
+
+// Consumption of a day under the tariffs of an offer type, false when it
+// cannot be split that way (HC/HP of BASE consumption, Tempo tariffs of
+// BASE consumption or of HC/HP consumption on a day of unknown colour).
+// The HC/HP split of the contract is kept, color being the Tempo colour
+// of the day if known.
+func offerTariffs(offerType string, tariffs map[string]float64, color string) (map[string]float64, bool) {
+	out := make(map[string]float64)
+	for tariff, v := range tariffs {
+		switch offerType {
+		case "base":
+			out["BASE"] += v
+		case "hchp":
+			if tariff == "BASE" {
+				return nil, false
+			}
+			if strings.HasSuffix(tariff, "HC") {
+				out["HC"] += v
+			} else {
+				out["HP"] += v
+			}
+		case "tempo":
+			switch {
+			case tariffColor(tariff) != "":
+				out[tariff] += v
+			case (tariff == "HC" || tariff == "HP") && tempoTariffPrefixes[color] != "":
+				out[tempoTariffPrefixes[color]+tariff] += v
+			default:
+				return nil, false
+			}
+		}
+	}
+	return out, true
+}
+
+// Re-price daily consumption, unrounded, under the contract prices, if any, and under
+// every offer, with the Tempo colours of days by date. Days that one of
+// them cannot price are skipped, offers that cannot price any day are left
+// out.
+func compareOffers(days []Consumption, current priceTable, all []OfferConfig, colors map[string]string) *Comparison {
+	cmp := &Comparison{}
+
+	var offers, unpriced []OfferConfig
+	for _, offer := range all {
+		priced := false
+		for _, day := range days {
+			if _, ok := priceDay(day.Date, day.KWh(), colors[day.Date], current, []OfferConfig{offer}); ok {
+				priced = true
+				break
+			}
+		}
+		if priced {
+			offers = append(offers, offer)
+		} else {
+			unpriced = append(unpriced, offer)
+		}
+	}
+
+	// Costs of each day: the contract first if priced, then offers
+	var dates []string
+	var costs [][]float64
+	for _, day := range days {
+		tariffs := day.KWh()
+		if len(tariffs) == 0 {
+			continue
+		}
+		row, ok := priceDay(day.Date, tariffs, colors[day.Date], current, offers)
+		if !ok {
+			cmp.SkippedDays++
+			continue
+		}
+		dates = append(dates, day.Date)
+		costs = append(costs, row)
+	}
+
+	if len(dates) > 0 {
+		cmp.From, cmp.To, cmp.Days = dates[0], dates[len(dates)-1], len(dates)
+	}
+
+	// Sum an offer's daily costs over the whole range, per year and month
+	sum := func(col int) (total float64, years, months []PeriodCost) {
+		byYear := make(map[string]*PeriodCost)
+		byMonth := make(map[string]*PeriodCost)
+		add := func(m map[string]*PeriodCost, key string, v float64) {
+			if m[key] == nil {
+				m[key] = &PeriodCost{Period: key}
+			}
+			m[key].Days++
+			m[key].Total += v
+		}
+		for i, date := range dates {
+			v := costs[i][col]
+			total += v
+			add(byYear, date[:4], v)
+			add(byMonth, date[:7], v)
+		}
+		periods := func(m map[string]*PeriodCost) []PeriodCost {
+			out := make([]PeriodCost, 0, len(m))
+			for _, key := range sortedKeys(m) {
+				p := *m[key]
+				p.Total = round2(p.Total)
+				out = append(out, p)
+			}
+			return out
+		}
+		return round2(total), periods(byYear), periods(byMonth)
+	}
+
+	first := 0
+	if len(current) > 0 {
+		total, years, months := sum(0)
+		cmp.Current = &OfferComparison{Name: "current", Total: total, Years: years, Months: months}
+		first = 1
+	}
+	cmp.Offers = make([]OfferComparison, 0, len(all))
+	for i, offer := range offers {
+		total, years, months := sum(first + i)
+		o := OfferComparison{Name: offer.Name, Type: offer.Type, Total: total, Years: years, Months: months}
+		if cmp.Current != nil {
+			o.Difference = difference(total, cmp.Current.Total)
+			for j := range o.Years {
+				o.Years[j].Difference = difference(o.Years[j].Total, cmp.Current.Years[j].Total)
+			}
+			for j := range o.Months {
+				o.Months[j].Difference = difference(o.Months[j].Total, cmp.Current.Months[j].Total)
+			}
+		}
+		cmp.Offers = append(cmp.Offers, o)
+	}
+
+	// Cheapest first
+	sort.SliceStable(cmp.Offers, func(i, j int) bool {
+		return cmp.Offers[i].Total < cmp.Offers[j].Total
+	})
+	for _, offer := range unpriced {
+		cmp.Offers = append(cmp.Offers, OfferComparison{
+			Name:   offer.Name,
+			Type:   offer.Type,
+			Years:  []PeriodCost{},
+			Months: []PeriodCost{},
+			Error:  "no day can be priced, check prices and tariffs of the consumption",
+		})
+	}
+	return cmp
+}
+
+// Cost of a day under the contract, if priced, then under every offer
+func priceDay(date string, tariffs map[string]float64, color string, current priceTable, offers []OfferConfig) ([]float64, bool) {
+	days := []string{date}
+	var row []float64
+	if len(current) > 0 {
+		cost := current.cost(tariffs, days)
+		if cost == nil {
+			return nil, false
+		}
+		row = append(row, cost.Total)
+	}
+	for _, offer := range offers {
+		mapped, ok := offerTariffs(offer.Type, tariffs, color)
+		if !ok {
+			return nil, false
+		}
+		cost := priceTable(offer.Prices).cost(mapped, days)
+		if cost == nil {
+			return nil, false
+		}
+		row = append(row, cost.Total)
+	}
+	return row, true
+}
+
+func difference(v, ref float64) *float64 {
+	d := round2(v - ref)
+	return &d
+}
+
+// HTTP handler for /api/electricity/compare?from=&to=, dates bounding the
+// accumulated consumption to re-price, all of it by default. HC/HP
+// consumption is split by the Tempo colours stored by the tempo source.
+func compareHandler(sources *SourceSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		electricity, ok := sources.Get("electricity").(*ElectricitySource)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if len(electricity.offers) == 0 {
+			writeResponse(w, r, ErrorResponse("no offers configured", time.Minute))
+			return
+		}
+
+		q := r.URL.Query()
+		for _, key := range []string{"from", "to"} {
+			if v := q.Get(key); v != "" {
+				if _, err := time.Parse(time.DateOnly, v); err != nil {
+					writeResponse(w, r, ErrorResponse("invalid "+key, time.Minute))
+					return
+				}
+			}
+		}
+		from, to := q.Get("from"), q.Get("to")
+
+		var days []Consumption
+		for _, day := range electricity.History() {
+			if (from == "" || day.Date >= from) && (to == "" || day.Date <= to) {
+				days = append(days, day)
+			}
+		}
+		var colors map[string]string
+		if tempo, ok := sources.Get("tempo").(*TempoSource); ok {
+			colors = tempo.Colors()
+		}
+		writeResponse(w, r, NewResponse(compareOffers(days, electricity.prices, electricity.offers, colors), time.Hour))
+	}
+}
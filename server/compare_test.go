@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOfferTariffs(t *testing.T) {
+	hchp := map[string]float64{"HC": 2.5, "HP": 4.25}
+	tests := []struct {
+		name      string
+		offerType string
+		tariffs   map[string]float64
+		color     string
+		want      map[string]float64
+	}{
+		{"base from hc/hp", "base", hchp, "", map[string]float64{"BASE": 6.75}},
+		{"hc/hp from base", "hchp", map[string]float64{"BASE": 6}, "", nil},
+		{"hc/hp from tempo", "hchp", map[string]float64{"BUHC": 2.5, "BUHP": 4.25}, "", hchp},
+		{"tempo from hc/hp on a blue day", "tempo", hchp, "blue", map[string]float64{"BUHC": 2.5, "BUHP": 4.25}},
+		{"tempo from hc/hp on a red day", "tempo", hchp, "red", map[string]float64{"RHC": 2.5, "RHP": 4.25}},
+		{"tempo from hc/hp of unknown colour", "tempo", hchp, "", nil},
+		{"tempo from tempo", "tempo", map[string]float64{"BCHC": 1, "BCHP": 2}, "red", map[string]float64{"BCHC": 1, "BCHP": 2}},
+		{"tempo from base", "tempo", map[string]float64{"BASE": 6}, "white", nil},
+	}
+	for _, tt := range tests {
+		got, ok := offerTariffs(tt.offerType, tt.tariffs, tt.color)
+		if ok != (tt.want != nil) || (ok && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("%s: %v %v, want %v", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+// HC/HP days are re-priced as Tempo by their colour, on unrounded
+// consumption, days of unknown colour being skipped
+func TestCompareOffers(t *testing.T) {
+	days := aggregateConsumption(map[string]map[string]float64{
+		"2025-01-10": {"HC": 2.4, "HP": 4.4},
+		"2025-01-11": {"HC": 2.4, "HP": 4.4},
+		"2025-02-01": {"HC": 2.4, "HP": 4.4},
+	}, 3)
+	colors := map[string]string{"2025-01-10": "blue", "2025-02-01": "red"}
+	current := priceTable{{From: "2025-01-01", Subscription: 0.5, KWh: map[string]float64{"HC": 0.2, "HP": 0.3}}}
+	offers := []OfferConfig{
+		{Name: "tempo", Type: "tempo", Prices: []PriceConfig{{From: "2025-01-01", Subscription: 0.5, KWh: map[string]float64{
+			"BUHC": 0.1, "BUHP": 0.2, "BCHC": 0.12, "BCHP": 0.25, "RHC": 0.15, "RHP": 0.7,
+		}}}},
+		{Name: "base", Type: "base", Prices: []PriceConfig{{From: "2025-01-01", Subscription: 0.5, KWh: map[string]float64{"BASE": 0.25}}}},
+		{Name: "later", Type: "base", Prices: []PriceConfig{{From: "2030-01-01", KWh: map[string]float64{"BASE": 0.1}}}},
+	}
+
+	cmp := compareOffers(days, current, offers, colors)
+	if cmp.Days != 2 || cmp.SkippedDays != 1 || cmp.From != "2025-01-10" || cmp.To != "2025-02-01" {
+		t.Errorf("%d days from %s to %s, %d skipped, want 2 from 2025-01-10 to 2025-02-01, 1 skipped", cmp.Days, cmp.From, cmp.To, cmp.SkippedDays)
+	}
+	if cmp.Current == nil || cmp.Current.Total != 4.6 {
+		t.Fatalf("current %+v, want 4.6", cmp.Current)
+	}
+
+	tests := []struct {
+		name       string
+		total      float64
+		difference float64
+		months     []float64
+	}{
+		{"base", 4.4, -0.2, []float64{2.2, 2.2}},
+		{"tempo", 5.56, 0.96, []float64{1.62, 3.94}},
+	}
+	if len(cmp.Offers) != 3 || cmp.Offers[2].Name != "later" || cmp.Offers[2].Error == "" {
+		t.Fatalf("offers %+v, want base, tempo then later without a priced day", cmp.Offers)
+	}
+	for i, tt := range tests {
+		o := cmp.Offers[i]
+		if o.Name != tt.name || o.Total != tt.total || o.Difference == nil || *o.Difference != tt.difference {
+			t.Errorf("offer %d: %s costing %v, want %s costing %v, %v compared to the contract", i, o.Name, o.Total, tt.name, tt.total, tt.difference)
+			continue
+		}
+		var months []float64
+		for _, m := range o.Months {
+			months = append(months, m.Total)
+		}
+		if !reflect.DeepEqual(months, tt.months) {
+			t.Errorf("%s: months %v, want %v", tt.name, months, tt.months)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validators and freshness of a JSON payload for HTTP caching
+type cacheMeta struct {
+	etag     string
+	modified time.Time
+	expires  time.Time
+}
+
+// Derive validators from a single response. The ETag only covers data and
+// error, so that a refresh returning the same data keeps it valid; refresh
+// times are left to Last-Modified. ETags are weak since the envelope's
+// timestamps and age change while the content stays the same.
+func responseMeta(resp *Response) cacheMeta {
+	h := sha256.New()
+	h.Write([]byte(resp.Error + "\x00"))
+	json.NewEncoder(h).Encode(resp.Data)
+
+	modified, _ := time.Parse(time.RFC3339, resp.Timestamp)
+	return cacheMeta{
+		etag:     `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`,
+		modified: modified,
+		expires:  resp.ExpiresAt,
+	}
+}
+
+// Combine validators across sources: newest modification, earliest expiry
+func combinedMeta(responses map[string]*Response) cacheMeta {
+	names := make([]string, 0, len(responses))
+	for name := range responses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var meta cacheMeta
+	h := sha256.New()
+	for _, name := range names {
+		resp := responses[name]
+		if resp == nil {
+			continue
+		}
+		m := responseMeta(resp)
+		h.Write([]byte(name + "=" + m.etag + "\x00"))
+		if m.modified.After(meta.modified) {
+			meta.modified = m.modified
+		}
+		if meta.expires.IsZero() || m.expires.Before(meta.expires) {
+			meta.expires = m.expires
+		}
+	}
+	meta.etag = `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+	return meta
+}
+
+// Write a source response with caching headers
+func writeResponse(w http.ResponseWriter, r *http.Request, resp *Response) {
+	writeCachedJSON(w, r, resp, responseMeta(resp))
+}
+
+// Write JSON with caching headers, or 304 if the client copy is current
+func writeCachedJSON(w http.ResponseWriter, r *http.Request, data any, meta cacheMeta) {
+	if writeCacheHeaders(w, r, meta) {
+		return
+	}
+	writeJSON(w, data)
+}
+
+// Set caching headers, and write 304 if the client copy is current
+func writeCacheHeaders(w http.ResponseWriter, r *http.Request, meta cacheMeta) bool {
+	maxAge := int(meta.expires.Sub(clock.Now()).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "max-age="+strconv.Itoa(maxAge))
+	h.Set("ETag", meta.etag)
+	if !meta.modified.IsZero() {
+		h.Set("Last-Modified", meta.modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, meta) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Evaluate If-None-Match with weak comparison, then If-Modified-Since
+func notModified(r *http.Request, meta cacheMeta) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(meta.etag, "W/")
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !meta.modified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !meta.modified.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Refreshes returning the same data keep the ETag, so clients revalidating
+// get 304 until the data changes
+func TestWriteResponseConditional(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	first := NewResponse("red", time.Hour)
+	c.Advance(10 * time.Minute)
+	refreshed := NewResponse("red", time.Hour)
+	changed := NewResponse("white", time.Hour)
+
+	get := func(resp *Response, header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/tempo", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		writeResponse(w, r, resp)
+		return w
+	}
+
+	w := get(first, "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first request: %d, ETag %q", w.Code, etag)
+	}
+	// The fake clock keeps running, so allow for a second elapsing
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=3000" && cc != "max-age=2999" {
+		t.Errorf("Cache-Control %q, want max-age=3000", cc)
+	}
+	if lm := w.Header().Get("Last-Modified"); lm != "Thu, 15 Jan 2026 11:00:00 GMT" {
+		t.Errorf("Last-Modified %q", lm)
+	}
+
+	tests := []struct {
+		name   string
+		resp   *Response
+		header string
+		value  string
+		code   int
+	}{
+		{"same data refreshed", refreshed, "If-None-Match", etag, http.StatusNotModified},
+		{"strong form of the tag", refreshed, "If-None-Match", etag[2:], http.StatusNotModified},
+		{"one of several tags", refreshed, "If-None-Match", `"other", ` + etag, http.StatusNotModified},
+		{"wildcard", changed, "If-None-Match", "*", http.StatusNotModified},
+		{"changed data", changed, "If-None-Match", etag, http.StatusOK},
+		{"error with the same data", ErrorResponse("down", time.Minute), "If-None-Match", etag, http.StatusOK},
+		{"not modified since", first, "If-Modified-Since", "Thu, 15 Jan 2026 11:00:00 GMT", http.StatusNotModified},
+		{"modified since", refreshed, "If-Modified-Since", "Thu, 15 Jan 2026 11:00:00 GMT", http.StatusOK},
+		{"invalid date", first, "If-Modified-Since", "yesterday", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get(tt.resp, tt.header, tt.value)
+		if w.Code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if tt.code == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: 304 with a body", tt.name)
+		}
+	}
+}
+
+func TestCombinedMeta(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	tempo := NewResponse("red", time.Hour)
+	c.Advance(time.Minute)
+	weather := NewResponse("sunny", 10*time.Minute)
+
+	meta := combinedMeta(map[string]*Response{"tempo": tempo, "weather": weather, "transport": nil})
+	if !meta.modified.Equal(clock.Now().Truncate(time.Second)) {
+		t.Errorf("modified %s, want the newest response", meta.modified)
+	}
+	if !meta.expires.Equal(weather.ExpiresAt) {
+		t.Errorf("expires %s, want the earliest expiry", meta.expires)
+	}
+
+	// The ETag depends on which source holds which data
+	swapped := combinedMeta(map[string]*Response{"tempo": weather, "weather": tempo})
+	if swapped.etag == meta.etag {
+		t.Error("same ETag with the data of two sources swapped")
+	}
+	if again := combinedMeta(map[string]*Response{"weather": weather, "tempo": tempo}); again.etag != meta.etag {
+		t.Error("ETag changed for the same responses")
+	}
+}
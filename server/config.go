@@ -1,71 +1,774 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Config is read from an optional YAML file (CONFIG_FILE), then
+// overridden by environment variables.
 type Config struct {
-	Port string
+	Port      string   `yaml:"port"`
+	CacheFile string   `yaml:"cache_file"`
+	Sources   []string `yaml:"sources"`
+
+	// Directory of the temperature, electricity and Tempo history, disabled if empty
+	HistoryDir string `yaml:"history_dir"`
+
+	// Start the server clock at a given time, for development only
+	ClockStart time.Time `yaml:"clock_start"`
+
+	Health HealthConfig `yaml:"health"`
+	HTTP   HTTPConfig   `yaml:"http"`
+	Auth   AuthConfig   `yaml:"auth"`
+	Alerts AlertsConfig `yaml:"alerts"`
+	MQTT   MQTTConfig   `yaml:"mqtt"`
+
+	Weather     WeatherConfig     `yaml:"weather"`
+	Transport   TransportConfig   `yaml:"transport"`
+	Electricity ElectricityConfig `yaml:"electricity"`
+	Tempo       TempoConfig       `yaml:"tempo"`
+	Temperature TemperatureConfig `yaml:"temperature"`
+}
+
+type HealthConfig struct {
+	Required []string `yaml:"required"`
+}
+
+// Optional access control for the dashboard and API, enabled as soon as a
+// user, token or kiosk is configured
+type AuthConfig struct {
+	Users      []UserConfig  `yaml:"users"`
+	Tokens     []string      `yaml:"tokens"`
+	Kiosks     []KioskConfig `yaml:"kiosks"`
+	SessionKey string        `yaml:"session_key"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+}
+
+type UserConfig struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
 
-	WeatherAPIURL    string
-	WeatherLatitude  float64
-	WeatherLongitude float64
-	WeatherTimezone  string
+// Long-lived token of a display device, revoked by removing it
+type KioskConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// Rules evaluated against cached source data, notified through sinks.
+// Only read from the config file.
+type AlertsConfig struct {
+	Rules []AlertRuleConfig `yaml:"rules"`
+	Sinks []AlertSinkConfig `yaml:"sinks"`
+	// Minimum delay between two notifications of a rule, unless set per rule
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// Rule of a given type: tempo (color, day), forecast, temperature and
+// humidity (below, above), sensor_silent, no_departures (line, stop).
+// The condition must hold for the For duration before the rule fires.
+type AlertRuleConfig struct {
+	Name     string        `yaml:"name"`
+	Type     string        `yaml:"type"`
+	Color    string        `yaml:"color"`
+	Day      string        `yaml:"day"`
+	Below    *float64      `yaml:"below"`
+	Above    *float64      `yaml:"above"`
+	Line     string        `yaml:"line"`
+	Stop     string        `yaml:"stop"`
+	For      time.Duration `yaml:"for"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// Notification sink of a given type: webhook (url), ntfy (url, token) or
+// smtp (host, port, username, password, from, to)
+type AlertSinkConfig struct {
+	Type     string   `yaml:"type"`
+	URL      string   `yaml:"url"`
+	Token    string   `yaml:"token"`
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// MQTT broker receiving source data and Home Assistant discovery
+// configs, disabled without broker
+type MQTTConfig struct {
+	// tcp://host:1883, or ssl://host:8883 for TLS
+	Broker          string `yaml:"broker"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	ClientID        string `yaml:"client_id"`
+	TopicPrefix     string `yaml:"topic_prefix"`
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+}
 
-	TransportAPIURL string
-	TransportAPIKey string
-	TransportStops  string
+// Upstream traffic recording (mode "record") or offline replay (mode "replay")
+type HTTPConfig struct {
+	Mode     string `yaml:"mode"`
+	Fixtures string `yaml:"fixtures"`
+}
+
+type WeatherConfig struct {
+	APIURL    string        `yaml:"api_url"`
+	Latitude  float64       `yaml:"latitude"`
+	Longitude float64       `yaml:"longitude"`
+	Timezone  string        `yaml:"timezone"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
 
-	ElectricityAPIURL   string
-	ElectricityClientID string
-	ElectricityUsername string
-	ElectricityPassword string
+type TransportConfig struct {
+	APIURL  string        `yaml:"api_url"`
+	APIKey  string        `yaml:"api_key"`
+	Stops   []StopConfig  `yaml:"stops"`
+	Timeout time.Duration `yaml:"timeout"`
 
-	TempoAPIURL    string
-	TempoAuthURL   string
-	TempoAuthToken string
+	// Live refreshes allowed per client and minute
+	LiveRateLimit int `yaml:"live_rate_limit"`
+	// CTS calls allowed per day, 0 for no limit
+	DailyBudget int `yaml:"daily_budget"`
 }
 
-// Read environment variables
-func LoadConfig() *Config {
+type StopConfig struct {
+	Line        string `yaml:"line"`
+	Stop        string `yaml:"stop"`
+	Destination string `yaml:"destination"`
+}
+
+type ElectricityConfig struct {
+	APIURL   string        `yaml:"api_url"`
+	ClientID string        `yaml:"client_id"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Years of consumption history to fetch, in pages going back in time
+	// from the oldest stored month, 0 to only keep syncing recent days
+	BackfillYears int `yaml:"backfill_years"`
+	// Directory of the consumption history, electricity/ under history_dir
+	// if empty, kept in memory if both are empty
+	HistoryDir string `yaml:"history_dir"`
+	// Latest days and months returned by /api/electricity
+	Days   int `yaml:"days"`
+	Months int `yaml:"months"`
+
+	// Contract prices, to compute costs, and alternative offers to compare
+	// it with. Only read from the config file.
+	Prices []PriceConfig `yaml:"prices"`
+	Offers []OfferConfig `yaml:"offers"`
+}
+
+// Alternative offer of a given type: base (BASE price), hchp (HC and HP
+// prices) or tempo (prices of the six Tempo tariffs)
+type OfferConfig struct {
+	Name   string        `yaml:"name"`
+	Type   string        `yaml:"type"`
+	Prices []PriceConfig `yaml:"prices"`
+}
+
+// Prices effective from a date (YYYY-MM-DD) until the next entry, or until
+// an optional exclusive end date. Energy prices in €/kWh are keyed by
+// tariff code (BASE, HC, HP, BUHC, BUHP, BCHC, BCHP, RHC, RHP), the
+// subscription is in € per day.
+type PriceConfig struct {
+	From         string             `yaml:"from"`
+	To           string             `yaml:"to"`
+	Subscription float64            `yaml:"subscription"`
+	KWh          map[string]float64 `yaml:"kwh"`
+}
+
+type TempoConfig struct {
+	APIURL    string        `yaml:"api_url"`
+	AuthURL   string        `yaml:"auth_url"`
+	AuthToken string        `yaml:"auth_token"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// Sensors allowed to push readings. Without sensors, pushes are not
+// authenticated.
+type TemperatureConfig struct {
+	Sensors []SensorConfig `yaml:"sensors"`
+}
+
+// A sensor identified by its bearer token, optionally bound to a location
+type SensorConfig struct {
+	Name     string `yaml:"name"`
+	Token    string `yaml:"token"`
+	Location string `yaml:"location"`
+}
+
+// Default values before file and environment
+func defaultConfig() *Config {
 	return &Config{
-		Port: getEnv("PORT", "80"),
+		Port:   "80",
+		HTTP:   HTTPConfig{Fixtures: "fixtures"},
+		Auth:   AuthConfig{SessionTTL: 30 * 24 * time.Hour},
+		Alerts: AlertsConfig{Cooldown: time.Hour},
+		MQTT: MQTTConfig{
+			ClientID:        "strasboard",
+			TopicPrefix:     "strasboard",
+			DiscoveryPrefix: "homeassistant",
+		},
+		Weather: WeatherConfig{
+			Latitude:  48.58,
+			Longitude: 7.75,
+			Timezone:  "Europe/Paris",
+			Timeout:   30 * time.Second,
+		},
+		Transport:   TransportConfig{Timeout: 20 * time.Second, LiveRateLimit: 6},
+		Electricity: ElectricityConfig{Timeout: time.Minute, Days: 14, Months: 2},
+		Tempo:       TempoConfig{Timeout: 20 * time.Second},
+	}
+}
+
+// Read config file and environment variables, reporting every problem at once
+func LoadConfig() (*Config, error) {
+	cfg := defaultConfig()
+	var errs []error
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	env := envLoader{}
+	env.str("PORT", &cfg.Port)
+	env.str("CACHE_FILE", &cfg.CacheFile)
+	env.str("HISTORY_DIR", &cfg.HistoryDir)
+	env.list("SOURCES", &cfg.Sources)
+	env.time("CLOCK_START", &cfg.ClockStart)
+
+	env.list("HEALTH_REQUIRED", &cfg.Health.Required)
+
+	env.users("AUTH_USERS", &cfg.Auth.Users)
+	env.list("AUTH_TOKENS", &cfg.Auth.Tokens)
+	env.kiosks("AUTH_KIOSKS", &cfg.Auth.Kiosks)
+	env.str("AUTH_SESSION_KEY", &cfg.Auth.SessionKey)
+	env.duration("AUTH_SESSION_TTL", &cfg.Auth.SessionTTL)
+
+	env.str("MQTT_BROKER", &cfg.MQTT.Broker)
+	env.str("MQTT_USERNAME", &cfg.MQTT.Username)
+	env.str("MQTT_PASSWORD", &cfg.MQTT.Password)
+	env.str("MQTT_CLIENT_ID", &cfg.MQTT.ClientID)
+	env.str("MQTT_TOPIC_PREFIX", &cfg.MQTT.TopicPrefix)
+	env.str("MQTT_DISCOVERY_PREFIX", &cfg.MQTT.DiscoveryPrefix)
+
+	env.str("HTTP_MODE", &cfg.HTTP.Mode)
+	env.str("HTTP_FIXTURES", &cfg.HTTP.Fixtures)
+
+	env.str("WEATHER_API_URL", &cfg.Weather.APIURL)
+	env.float("WEATHER_LATITUDE", &cfg.Weather.Latitude)
+	env.float("WEATHER_LONGITUDE", &cfg.Weather.Longitude)
+	env.str("WEATHER_TIMEZONE", &cfg.Weather.Timezone)
+	env.duration("WEATHER_TIMEOUT", &cfg.Weather.Timeout)
+
+	env.str("TRANSPORT_API_URL", &cfg.Transport.APIURL)
+	env.str("TRANSPORT_API_KEY", &cfg.Transport.APIKey)
+	env.stops("TRANSPORT_STOPS", &cfg.Transport.Stops)
+	env.duration("TRANSPORT_TIMEOUT", &cfg.Transport.Timeout)
+	env.int("TRANSPORT_LIVE_RATE_LIMIT", &cfg.Transport.LiveRateLimit)
+	env.int("TRANSPORT_DAILY_BUDGET", &cfg.Transport.DailyBudget)
+
+	env.str("ELECTRICITY_API_URL", &cfg.Electricity.APIURL)
+	env.str("ELECTRICITY_CLIENT_ID", &cfg.Electricity.ClientID)
+	env.str("ELECTRICITY_USERNAME", &cfg.Electricity.Username)
+	env.str("ELECTRICITY_PASSWORD", &cfg.Electricity.Password)
+	env.duration("ELECTRICITY_TIMEOUT", &cfg.Electricity.Timeout)
+	env.int("ELECTRICITY_BACKFILL_YEARS", &cfg.Electricity.BackfillYears)
+	env.str("ELECTRICITY_HISTORY_DIR", &cfg.Electricity.HistoryDir)
+	env.int("ELECTRICITY_DAYS", &cfg.Electricity.Days)
+	env.int("ELECTRICITY_MONTHS", &cfg.Electricity.Months)
+
+	env.str("TEMPO_API_URL", &cfg.Tempo.APIURL)
+	env.str("TEMPO_AUTH_URL", &cfg.Tempo.AuthURL)
+	env.str("TEMPO_AUTH_TOKEN", &cfg.Tempo.AuthToken)
+	env.duration("TEMPO_TIMEOUT", &cfg.Tempo.Timeout)
+
+	env.sensors("TEMPERATURE_SENSORS", &cfg.Temperature.Sensors)
+
+	errs = append(errs, env.errs...)
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+// Decode a YAML config file, rejecting unknown keys
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Check values for consistency
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p <= 0 || p > 65535 {
+		fail("port: invalid port %q", c.Port)
+	}
+	for _, name := range c.Sources {
+		if _, ok := sourceFactories[name]; !ok {
+			fail("sources: unknown source %q", name)
+		}
+	}
+	for _, name := range c.Health.Required {
+		if _, ok := sourceFactories[name]; !ok {
+			fail("health.required: unknown source %q", name)
+		}
+	}
+	switch c.HTTP.Mode {
+	case "", "record", "replay":
+	default:
+		fail("http.mode: must be record or replay, got %q", c.HTTP.Mode)
+	}
+	if c.HTTP.Mode != "" && c.HTTP.Fixtures == "" {
+		fail("http.fixtures: required with http.mode")
+	}
+
+	checkURL := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("%s: invalid URL %q", field, value)
+		}
+	}
+	checkTimeout := func(field string, d time.Duration) {
+		if d <= 0 {
+			fail("%s: must be positive", field)
+		}
+	}
+
+	for i, user := range c.Auth.Users {
+		if user.Name == "" || user.Password == "" {
+			fail("auth.users[%d]: name and password are required", i)
+		}
+	}
+	for i, token := range c.Auth.Tokens {
+		if token == "" {
+			fail("auth.tokens[%d]: empty token", i)
+		}
+	}
+	for i, kiosk := range c.Auth.Kiosks {
+		if kiosk.Name == "" || kiosk.Token == "" {
+			fail("auth.kiosks[%d]: name and token are required", i)
+		}
+	}
+	if c.Auth.SessionKey != "" && len(c.Auth.SessionKey) < 32 {
+		fail("auth.session_key: must be at least 32 characters")
+	}
+	checkTimeout("auth.session_ttl", c.Auth.SessionTTL)
+
+	names := make(map[string]bool)
+	for i, rule := range c.Alerts.Rules {
+		field := fmt.Sprintf("alerts.rules[%d]", i)
+		if rule.Name == "" {
+			fail("%s: name is required", field)
+		}
+		if names[rule.Name] {
+			fail("%s: duplicate name %q", field, rule.Name)
+		}
+		names[rule.Name] = true
+		switch rule.Type {
+		case "tempo":
+			if rule.Color != "blue" && rule.Color != "white" && rule.Color != "red" {
+				fail("%s: color must be blue, white or red", field)
+			}
+			if rule.Day != "" && rule.Day != "today" && rule.Day != "tomorrow" {
+				fail("%s: day must be today or tomorrow", field)
+			}
+		case "forecast", "temperature", "humidity":
+			if rule.Below == nil && rule.Above == nil {
+				fail("%s: below or above is required", field)
+			}
+		case "sensor_silent":
+			if rule.For <= 0 {
+				fail("%s: for is required", field)
+			}
+		case "no_departures":
+		default:
+			fail("%s: unknown type %q", field, rule.Type)
+		}
+		if rule.For < 0 || rule.Cooldown < 0 {
+			fail("%s: for and cooldown must not be negative", field)
+		}
+	}
+	for i, sink := range c.Alerts.Sinks {
+		field := fmt.Sprintf("alerts.sinks[%d]", i)
+		switch sink.Type {
+		case "webhook", "ntfy":
+			if sink.URL == "" {
+				fail("%s: url is required", field)
+			}
+			checkURL(field+".url", sink.URL)
+		case "smtp":
+			if sink.Host == "" || sink.From == "" || len(sink.To) == 0 {
+				fail("%s: host, from and to are required", field)
+			}
+			if sink.Port < 0 || sink.Port > 65535 {
+				fail("%s: invalid port %d", field, sink.Port)
+			}
+		default:
+			fail("%s: type must be webhook, ntfy or smtp, got %q", field, sink.Type)
+		}
+	}
+	if c.Alerts.Cooldown < 0 {
+		fail("alerts.cooldown: must not be negative")
+	}
 
-		WeatherAPIURL:    getEnv("WEATHER_API_URL", ""),
-		WeatherLatitude:  getEnvFloat("WEATHER_LATITUDE", 48.58),
-		WeatherLongitude: getEnvFloat("WEATHER_LONGITUDE", 7.75),
-		WeatherTimezone:  getEnv("WEATHER_TIMEZONE", "Europe/Paris"),
+	if c.MQTT.Broker != "" {
+		u, err := url.Parse(c.MQTT.Broker)
+		switch {
+		case err != nil || u.Host == "":
+			fail("mqtt.broker: invalid URL %q", c.MQTT.Broker)
+		case u.Scheme != "tcp" && u.Scheme != "mqtt" && u.Scheme != "ssl" && u.Scheme != "mqtts":
+			fail("mqtt.broker: scheme must be tcp, mqtt, ssl or mqtts, got %q", u.Scheme)
+		}
+		// Also used as Home Assistant node and unique ID prefix
+		if c.MQTT.ClientID == "" || len(c.MQTT.ClientID) > 23 || strings.Trim(c.MQTT.ClientID, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") != "" {
+			fail("mqtt.client_id: must be 1 to 23 letters, digits, - or _")
+		}
+		checkPrefix := func(field, prefix string) {
+			if prefix == "" || strings.ContainsAny(prefix, "+#") || strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+				fail("%s: invalid topic prefix %q", field, prefix)
+			}
+		}
+		checkPrefix("mqtt.topic_prefix", c.MQTT.TopicPrefix)
+		checkPrefix("mqtt.discovery_prefix", c.MQTT.DiscoveryPrefix)
+	}
 
-		TransportAPIURL: getEnv("TRANSPORT_API_URL", ""),
-		TransportAPIKey: getEnv("TRANSPORT_API_KEY", ""),
-		TransportStops:  getEnv("TRANSPORT_STOPS", ""),
+	checkURL("weather.api_url", c.Weather.APIURL)
+	if c.Weather.Latitude < -90 || c.Weather.Latitude > 90 {
+		fail("weather.latitude: %v out of range", c.Weather.Latitude)
+	}
+	if c.Weather.Longitude < -180 || c.Weather.Longitude > 180 {
+		fail("weather.longitude: %v out of range", c.Weather.Longitude)
+	}
+	if _, err := time.LoadLocation(c.Weather.Timezone); err != nil {
+		fail("weather.timezone: %v", err)
+	}
+	checkTimeout("weather.timeout", c.Weather.Timeout)
 
-		ElectricityAPIURL:   getEnv("ELECTRICITY_API_URL", ""),
-		ElectricityClientID: getEnv("ELECTRICITY_CLIENT_ID", ""),
-		ElectricityUsername: getEnv("ELECTRICITY_USERNAME", ""),
-		ElectricityPassword: getEnv("ELECTRICITY_PASSWORD", ""),
+	checkURL("transport.api_url", c.Transport.APIURL)
+	for i, stop := range c.Transport.Stops {
+		if stop.Line == "" || stop.Stop == "" || stop.Destination == "" {
+			fail("transport.stops[%d]: line, stop and destination are required", i)
+		}
+	}
+	if c.Transport.APIKey != "" && c.Transport.APIURL == "" {
+		fail("transport.api_url: required with transport.api_key")
+	}
+	checkTimeout("transport.timeout", c.Transport.Timeout)
+	if c.Transport.LiveRateLimit <= 0 {
+		fail("transport.live_rate_limit: must be positive")
+	}
+	if c.Transport.DailyBudget < 0 {
+		fail("transport.daily_budget: must not be negative")
+	}
 
-		TempoAPIURL:    getEnv("TEMPO_API_URL", ""),
-		TempoAuthURL:   getEnv("TEMPO_AUTH_URL", ""),
-		TempoAuthToken: getEnv("TEMPO_AUTH_TOKEN", ""),
+	checkURL("electricity.api_url", c.Electricity.APIURL)
+	if c.Electricity.Username != "" && (c.Electricity.APIURL == "" || c.Electricity.ClientID == "") {
+		fail("electricity: api_url and client_id are required with username")
+	}
+	checkTimeout("electricity.timeout", c.Electricity.Timeout)
+	if c.Electricity.BackfillYears < 0 {
+		fail("electricity.backfill_years: must not be negative")
+	}
+	if c.Electricity.Days < 1 {
+		fail("electricity.days: must be at least 1")
+	}
+	if c.Electricity.Months < 1 {
+		fail("electricity.months: must be at least 1")
+	}
+	errs = append(errs, checkPrices("electricity.prices", c.Electricity.Prices)...)
+	offers := make(map[string]bool)
+	for i, offer := range c.Electricity.Offers {
+		field := fmt.Sprintf("electricity.offers[%d]", i)
+		if offer.Name == "" {
+			fail("%s: name is required", field)
+		}
+		if offers[offer.Name] {
+			fail("%s: duplicate name %q", field, offer.Name)
+		}
+		offers[offer.Name] = true
+		required, ok := offerTariffCodes[offer.Type]
+		if !ok {
+			fail("%s: type must be base, hchp or tempo, got %q", field, offer.Type)
+		}
+		if len(offer.Prices) == 0 {
+			fail("%s: prices are required", field)
+		}
+		errs = append(errs, checkPrices(field+".prices", offer.Prices)...)
+		for j, p := range offer.Prices {
+			for _, tariff := range required {
+				if _, ok := p.KWh[tariff]; !ok {
+					fail("%s.prices[%d].kwh: %s is required", field, j, tariff)
+				}
+			}
+		}
 	}
+
+	checkURL("tempo.api_url", c.Tempo.APIURL)
+	checkURL("tempo.auth_url", c.Tempo.AuthURL)
+	if c.Tempo.AuthToken != "" && (c.Tempo.APIURL == "" || c.Tempo.AuthURL == "") {
+		fail("tempo: api_url and auth_url are required with auth_token")
+	}
+	checkTimeout("tempo.timeout", c.Tempo.Timeout)
+
+	tokens := make(map[string]bool)
+	for i, sensor := range c.Temperature.Sensors {
+		if sensor.Name == "" || sensor.Token == "" {
+			fail("temperature.sensors[%d]: name and token are required", i)
+		}
+		if tokens[sensor.Token] {
+			fail("temperature.sensors[%d]: token already used by another sensor", i)
+		}
+		tokens[sensor.Token] = true
+	}
+
+	return errs
+}
+
+// Check a price table: valid dates, known tariffs, no overlapping periods
+func checkPrices(field string, prices []PriceConfig) []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	var prevFrom, prevTo time.Time
+	for i, p := range prices {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		from, err := time.Parse(time.DateOnly, p.From)
+		if err != nil {
+			fail("%s.from: invalid date %q", f, p.From)
+			continue
+		}
+		var to time.Time
+		if p.To != "" {
+			if to, err = time.Parse(time.DateOnly, p.To); err != nil || !to.After(from) {
+				fail("%s.to: invalid end date %q", f, p.To)
+			}
+		}
+		if i > 0 && (!from.After(prevFrom) || (!prevTo.IsZero() && from.Before(prevTo))) {
+			fail("%s.from: periods must be sorted and not overlap", f)
+		}
+		prevFrom, prevTo = from, to
+
+		if p.Subscription < 0 {
+			fail("%s.subscription: must not be negative", f)
+		}
+		for _, tariff := range sortedKeys(p.KWh) {
+			if _, ok := tariffNames[tariff]; !ok {
+				fail("%s.kwh: unknown tariff %q", f, tariff)
+			}
+			if p.KWh[tariff] < 0 {
+				fail("%s.kwh.%s: must not be negative", f, tariff)
+			}
+		}
+	}
+	return errs
+}
+
+// Config section of a source, matched by YAML key
+func (c *Config) Section(name string) any {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == name {
+			return v.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+// Environment variable reader collecting parse errors
+type envLoader struct {
+	errs []error
 }
 
-// Get a string env variable
-func getEnv(key, defaultValue string) string {
+// Read a string env variable
+func (e *envLoader) str(key string, dst *string) {
 	if value := os.Getenv(key); value != "" {
-		return value
+		*dst = value
 	}
-	return defaultValue
 }
 
-// Get a float env variable
-func getEnvFloat(key string, defaultValue float64) float64 {
+// Read a float env variable
+func (e *envLoader) float(key string, dst *float64) {
 	if value := os.Getenv(key); value != "" {
-		if f, err := strconv.ParseFloat(value, 64); err == nil {
-			return f
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid number %q", key, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+// Read an integer env variable
+func (e *envLoader) int(key string, dst *int) {
+	if value := os.Getenv(key); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", key, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+// Read a duration env variable
+func (e *envLoader) duration(key string, dst *time.Duration) {
+	if value := os.Getenv(key); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid duration %q", key, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+// Read an RFC 3339 time env variable
+func (e *envLoader) time(key string, dst *time.Time) {
+	if value := os.Getenv(key); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid time %q", key, value))
+			return
+		}
+		*dst = t
+	}
+}
+
+// Read a comma-separated list env variable
+func (e *envLoader) list(key string, dst *[]string) {
+	if value := os.Getenv(key); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+// Read stops in format "line,stop,destination;line,stop,destination;..."
+func (e *envLoader) stops(key string, dst *[]StopConfig) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	var stops []StopConfig
+	for i, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ",", 3)
+		if len(parts) != 3 {
+			e.errs = append(e.errs, fmt.Errorf("%s: entry %d %q must be line,stop,destination", key, i, entry))
+			continue
+		}
+		stops = append(stops, StopConfig{
+			Line:        strings.TrimSpace(parts[0]),
+			Stop:        strings.TrimSpace(parts[1]),
+			Destination: strings.TrimSpace(parts[2]),
+		})
+	}
+	*dst = stops
+}
+
+// Read sensors in format "name,token[,location];name,token[,location];..."
+func (e *envLoader) sensors(key string, dst *[]SensorConfig) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	var sensors []SensorConfig
+	for i, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ",", 3)
+		if len(parts) < 2 {
+			e.errs = append(e.errs, fmt.Errorf("%s: entry %d must be name,token[,location]", key, i))
+			continue
+		}
+		sensor := SensorConfig{
+			Name:  strings.TrimSpace(parts[0]),
+			Token: strings.TrimSpace(parts[1]),
+		}
+		if len(parts) == 3 {
+			sensor.Location = strings.TrimSpace(parts[2])
+		}
+		sensors = append(sensors, sensor)
+	}
+	*dst = sensors
+}
+
+// Read users in format "name:password,name:password,..."
+func (e *envLoader) users(key string, dst *[]UserConfig) {
+	var items []string
+	e.list(key, &items)
+	if items == nil {
+		return
+	}
+	var users []UserConfig
+	for i, item := range items {
+		name, password, ok := strings.Cut(item, ":")
+		if !ok {
+			e.errs = append(e.errs, fmt.Errorf("%s: entry %d must be name:password", key, i))
+			continue
+		}
+		users = append(users, UserConfig{Name: name, Password: password})
+	}
+	*dst = users
+}
+
+// Read kiosks in format "name:token,name:token,..."
+func (e *envLoader) kiosks(key string, dst *[]KioskConfig) {
+	var items []string
+	e.list(key, &items)
+	if items == nil {
+		return
+	}
+	var kiosks []KioskConfig
+	for i, item := range items {
+		name, token, ok := strings.Cut(item, ":")
+		if !ok {
+			e.errs = append(e.errs, fmt.Errorf("%s: entry %d must be name:token", key, i))
+			continue
 		}
+		kiosks = append(kiosks, KioskConfig{Name: name, Token: token})
 	}
-	return defaultValue
+	*dst = kiosks
 }
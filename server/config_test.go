@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		env   map[string]string
+		err   string
+		check func(*Config) bool
+	}{
+		{
+			name: "defaults",
+			check: func(c *Config) bool {
+				return c.Port == "80" && c.Weather.Timezone == "Europe/Paris" && c.Auth.SessionTTL == 30*24*time.Hour
+			},
+		},
+		{
+			name: "file over defaults",
+			yaml: `
+port: "8080"
+sources: [tempo, weather]
+weather:
+  latitude: 48.5
+  timeout: 10s
+transport:
+  stops:
+    - {line: A, stop: Homme de Fer, destination: Illkirch}
+`,
+			check: func(c *Config) bool {
+				return c.Port == "8080" && len(c.Sources) == 2 && c.Weather.Latitude == 48.5 &&
+					c.Weather.Longitude == 7.75 && c.Weather.Timeout == 10*time.Second &&
+					len(c.Transport.Stops) == 1 && c.Transport.Stops[0].Stop == "Homme de Fer"
+			},
+		},
+		{
+			name: "environment over file",
+			yaml: `
+port: "8080"
+sources: [tempo]
+temperature:
+  sensors: [{name: living, token: t1}]
+`,
+			env: map[string]string{
+				"PORT":                "9090",
+				"SOURCES":             "weather, transport,",
+				"TRANSPORT_STOPS":     "A,Homme de Fer,Illkirch; C,Gallia,Neuhof",
+				"TEMPERATURE_SENSORS": "kitchen,t2,Kitchen",
+				"WEATHER_TIMEOUT":     "5s",
+			},
+			check: func(c *Config) bool {
+				return c.Port == "9090" && strings.Join(c.Sources, ",") == "weather,transport" &&
+					len(c.Transport.Stops) == 2 && c.Transport.Stops[1].Destination == "Neuhof" &&
+					len(c.Temperature.Sensors) == 1 && c.Temperature.Sensors[0].Location == "Kitchen" &&
+					c.Weather.Timeout == 5*time.Second
+			},
+		},
+		{
+			name: "unknown key",
+			yaml: "weather:\n  lattitude: 48.5\n",
+			err:  "field lattitude not found",
+		},
+		{
+			name: "every problem reported",
+			yaml: `
+port: "0"
+sources: [tempo, meteo]
+electricity:
+  username: alice
+`,
+			env: map[string]string{
+				"WEATHER_LATITUDE":       "north",
+				"TRANSPORT_DAILY_BUDGET": "-1",
+				"HTTP_MODE":              "capture",
+			},
+			err: `WEATHER_LATITUDE: invalid number "north"
+port: invalid port "0"
+sources: unknown source "meteo"
+http.mode: must be record or replay, got "capture"
+transport.daily_budget: must not be negative
+electricity: api_url and client_id are required with username`,
+		},
+		{
+			name: "overlapping prices",
+			yaml: `
+electricity:
+  prices:
+    - {from: "2025-02-01", subscription: 0.5, kwh: {BASE: 0.2}}
+    - {from: "2025-01-01", kwh: {BASE: -0.1, XX: 0.1}}
+`,
+			err: `electricity.prices[1].from: periods must be sorted and not overlap
+electricity.prices[1].kwh.BASE: must not be negative
+electricity.prices[1].kwh: unknown tariff "XX"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.yaml != "" {
+				path = filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("CONFIG_FILE", path)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := LoadConfig()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if tt.check != nil && !tt.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
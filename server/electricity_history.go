@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Granularities of /api/electricity queries
+var consumptionGranularities = []string{"day", "week", "month", "year"}
+
+// consumptionStore keeps every daily and monthly value fetched from SER, by
+// date (YYYY-MM-DD) or month (YYYY-MM) and tariff. With a directory, values
+// are persisted as CSV files rewritten on change: days.csv and months.csv
+// with date, tariff and value records, and backfill holding the oldest
+// month requested by the backfill.
+type consumptionStore struct {
+	dir string // empty to keep values in memory only
+
+	mu         sync.Mutex
+	days       map[string]map[string]float64
+	months     map[string]map[string]float64
+	backfilled string
+}
+
+// ConsumptionHistory is returned by /api/electricity with query parameters
+type ConsumptionHistory struct {
+	Granularity string        `json:"granularity"`
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Values      []Consumption `json:"values"`
+}
+
+// Open the store in dir, in memory only if empty
+func newConsumptionStore(dir string) (*consumptionStore, error) {
+	s := &consumptionStore{
+		dir:    dir,
+		days:   make(map[string]map[string]float64),
+		months: make(map[string]map[string]float64),
+	}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("electricity history: %w", err)
+	}
+	for name, m := range map[string]map[string]map[string]float64{"days.csv": s.days, "months.csv": s.months} {
+		err := readRecords(filepath.Join(dir, name), func(rec []string) {
+			if len(rec) != 3 {
+				return
+			}
+			v, err := strconv.ParseFloat(rec[2], 64)
+			if err != nil {
+				return
+			}
+			if m[rec[0]] == nil {
+				m[rec[0]] = make(map[string]float64)
+			}
+			m[rec[0]][rec[1]] = v
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("electricity history: %w", err)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "backfill")); err == nil {
+		s.backfilled = strings.TrimSpace(string(b))
+	}
+	log.Printf("[electricity] history opened %s, %d days and %d months", dir, len(s.days), len(s.months))
+	return s, nil
+}
+
+// Record fetched values, replacing those of the same days and months
+func (s *consumptionStore) merge(days, months map[string]map[string]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for name, m := range map[string]map[string]map[string]float64{"days.csv": days, "months.csv": months} {
+		stored := s.days
+		if name == "months.csv" {
+			stored = s.months
+		}
+		changed := false
+		for date, values := range m {
+			if !maps.Equal(stored[date], values) {
+				stored[date] = values
+				changed = true
+			}
+		}
+		if changed && s.dir != "" {
+			errs = append(errs, writeFileAtomic(filepath.Join(s.dir, name), consumptionRecords(stored)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CSV records sorted by date and tariff
+func consumptionRecords(m map[string]map[string]float64) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, date := range sortedKeys(m) {
+		for _, tariff := range sortedKeys(m[date]) {
+			w.Write([]string{date, tariff, formatValue(m[date][tariff])})
+		}
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// Oldest month (YYYY-MM) covered, requested by the backfill or else
+// stored, empty if none
+func (s *consumptionStore) oldest() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest := s.backfilled
+	for month := range s.months {
+		if oldest == "" || month < oldest {
+			oldest = month
+		}
+	}
+	for date := range s.days {
+		if oldest == "" || date[:7] < oldest {
+			oldest = date[:7]
+		}
+	}
+	return oldest
+}
+
+// Record the oldest month requested by the backfill
+func (s *consumptionStore) setBackfilled(month string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backfilled = month
+	if s.dir == "" {
+		return nil
+	}
+	return writeFileAtomic(filepath.Join(s.dir, "backfill"), []byte(month+"\n"))
+}
+
+// Latest stored day, empty if none
+func (s *consumptionStore) lastDay() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := ""
+	for date := range s.days {
+		last = max(last, date)
+	}
+	return last
+}
+
+// Copy of the stored values, safe to read while fetching
+func (s *consumptionStore) snapshot() (days, months map[string]map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.days), maps.Clone(s.months)
+}
+
+// Consumption per period between two dates (YYYY-MM-DD, inclusive). Days
+// and weeks sum daily values. Months and years sum the monthly values of
+// SER, which are also available when days are missing, and include months
+// overlapping the range. Costs are summed over the days or months of a
+// period when all of them can be priced.
+func (s *ElectricitySource) Query(granularity, from, to string) *ConsumptionHistory {
+	days, months := s.store.snapshot()
+	last := ""
+	if keys := sortedKeys(days); len(keys) > 0 {
+		last = keys[len(keys)-1]
+	}
+
+	units := days
+	inRange := func(date string) bool { return date >= from && date <= to }
+	if granularity == "month" || granularity == "year" {
+		units = months
+		inRange = func(month string) bool { return month >= from[:7] && month <= to[:7] }
+	}
+	period := func(key string) string {
+		switch granularity {
+		case "week":
+			t, _ := time.Parse(time.DateOnly, key)
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		case "year":
+			return key[:4]
+		}
+		return key
+	}
+
+	periods := make(map[string]map[string]float64)
+	costs := make(map[string][]*Cost)
+	for key, values := range units {
+		if !inRange(key) {
+			continue
+		}
+		p := period(key)
+		if periods[p] == nil {
+			periods[p] = make(map[string]float64)
+		}
+		for tariff, v := range values {
+			periods[p][tariff] += v
+		}
+		if len(s.prices) > 0 {
+			dates := []string{key}
+			if len(key) == len("2006-01") {
+				dates, _ = monthDays(key, last)
+			}
+			costs[p] = append(costs[p], s.prices.cost(values, dates))
+		}
+	}
+
+	values := aggregateConsumption(periods, len(periods))
+	for i := range values {
+		values[i].Cost = sumCosts(costs[values[i].Date])
+	}
+	return &ConsumptionHistory{Granularity: granularity, From: from, To: to, Values: values}
+}
+
+// Sum of costs, nil if there are none or one of them is nil
+func sumCosts(costs []*Cost) *Cost {
+	if len(costs) == 0 {
+		return nil
+	}
+	sum := &Cost{Tariffs: make(map[string]float64)}
+	for _, c := range costs {
+		if c == nil {
+			return nil
+		}
+		sum.Total += c.Total
+		sum.Subscription += c.Subscription
+		for tariff, v := range c.Tariffs {
+			sum.Tariffs[tariff] += v
+		}
+		for color, v := range c.Colors {
+			if sum.Colors == nil {
+				sum.Colors = make(map[string]float64)
+			}
+			sum.Colors[color] += v
+		}
+	}
+	sum.Total = round2(sum.Total)
+	sum.Subscription = round2(sum.Subscription)
+	for tariff, v := range sum.Tariffs {
+		sum.Tariffs[tariff] = round2(v)
+	}
+	for color, v := range sum.Colors {
+		sum.Colors[color] = round2(v)
+	}
+	return sum
+}
+
+// HTTP handler for /api/electricity?from=&to=&granularity=, from and to
+// being dates bounding the stored consumption, all of it by default, and
+// granularity day (default), week, month or year
+func (s *ElectricitySource) HistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		for _, key := range []string{"from", "to"} {
+			if v := q.Get(key); v != "" {
+				if _, err := time.Parse(time.DateOnly, v); err != nil {
+					writeResponse(w, r, ErrorResponse("invalid "+key, time.Minute))
+					return
+				}
+			}
+		}
+		granularity := q.Get("granularity")
+		if granularity == "" {
+			granularity = "day"
+		}
+		if !slices.Contains(consumptionGranularities, granularity) {
+			writeResponse(w, r, ErrorResponse("granularity must be one of "+strings.Join(consumptionGranularities, ", "), time.Minute))
+			return
+		}
+
+		from, to := q.Get("from"), q.Get("to")
+		if from == "" {
+			from = "0001-01-01"
+			if oldest := s.store.oldest(); oldest != "" {
+				from = oldest + "-01"
+			}
+		}
+		if to == "" {
+			to = clock.Now().In(s.loc).Format(time.DateOnly)
+		}
+		if from > to {
+			writeResponse(w, r, ErrorResponse("from must be before to", time.Minute))
+			return
+		}
+		writeResponse(w, r, NewResponse(s.Query(granularity, from, to), time.Minute))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Values are merged by date and survive a reopen, the oldest month
+// covering the backfill as well
+func TestConsumptionStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newConsumptionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldest := s.oldest(); oldest != "" {
+		t.Errorf("oldest %q of an empty store", oldest)
+	}
+
+	err = s.merge(
+		map[string]map[string]float64{"2025-03-01": {"HC": 4.2, "HP": 6.1}, "2025-03-02": {"HC": 3}},
+		map[string]map[string]float64{"2025-03": {"HC": 7.2, "HP": 6.1}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.merge(
+		map[string]map[string]float64{"2025-03-02": {"HC": 3.5, "HP": 5}, "2025-02-28": {"HC": 1}},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setBackfilled("2024-11"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newConsumptionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, months := reopened.snapshot()
+	wantDays := map[string]map[string]float64{
+		"2025-02-28": {"HC": 1},
+		"2025-03-01": {"HC": 4.2, "HP": 6.1},
+		"2025-03-02": {"HC": 3.5, "HP": 5},
+	}
+	if !reflect.DeepEqual(days, wantDays) {
+		t.Errorf("days %v, want %v", days, wantDays)
+	}
+	if !reflect.DeepEqual(months, map[string]map[string]float64{"2025-03": {"HC": 7.2, "HP": 6.1}}) {
+		t.Errorf("months %v", months)
+	}
+	if last := reopened.lastDay(); last != "2025-03-02" {
+		t.Errorf("last day %s, want 2025-03-02", last)
+	}
+	if oldest := reopened.oldest(); oldest != "2024-11" {
+		t.Errorf("oldest %s, want the backfilled 2024-11", oldest)
+	}
+
+	reopened.setBackfilled("")
+	if oldest := reopened.oldest(); oldest != "2025-02" {
+		t.Errorf("oldest %s, want 2025-02 from the days", oldest)
+	}
+}
+
+func TestConsumptionQuery(t *testing.T) {
+	store, _ := newConsumptionStore("")
+	store.merge(
+		map[string]map[string]float64{
+			"2025-12-30": {"HC": 2.4, "HP": 4.4},
+			"2025-12-31": {"HC": 2.4, "HP": 4.4},
+			"2026-01-01": {"HC": 2.4, "HP": 4.4},
+			"2026-01-05": {"HC": 2.4, "HP": 4.4},
+		},
+		map[string]map[string]float64{
+			"2025-11": {"HC": 100.4, "HP": 150.4},
+			"2025-12": {"HC": 120.4, "HP": 180.4},
+			"2026-01": {"HC": 10.4, "HP": 20.4},
+		},
+	)
+	s := &ElectricitySource{store: store, prices: priceTable{
+		{From: "2025-12-01", Subscription: 0.5, KWh: map[string]float64{"HC": 0.2, "HP": 0.3}},
+	}}
+
+	type value struct {
+		date   string
+		hc, hp int
+		cost   float64 // 0 for none
+	}
+	tests := []struct {
+		granularity, from, to string
+		want                  []value
+	}{
+		{"day", "2025-12-31", "2026-01-04", []value{
+			{"2025-12-31", 2, 4, 2.3},
+			{"2026-01-01", 2, 4, 2.3},
+		}},
+		// ISO weeks, 2026-W01 starting on Monday 2025-12-29
+		{"week", "2025-12-01", "2026-01-31", []value{
+			{"2026-W01", 7, 13, 6.9},
+			{"2026-W02", 2, 4, 2.3},
+		}},
+		// Overlapping months, November being unpriced. January is priced
+		// up to the latest day.
+		{"month", "2025-11-15", "2026-01-02", []value{
+			{"2025-11", 100, 150, 0},
+			{"2025-12", 120, 180, 93.7},
+			{"2026-01", 10, 20, 10.7},
+		}},
+		{"year", "2025-12-01", "2026-12-31", []value{
+			{"2025", 120, 180, 93.7},
+			{"2026", 10, 20, 10.7},
+		}},
+	}
+	for _, tt := range tests {
+		h := s.Query(tt.granularity, tt.from, tt.to)
+		var got []value
+		for _, v := range h.Values {
+			got = append(got, value{date: v.Date})
+			if v.HC != nil && v.HP != nil {
+				got[len(got)-1].hc, got[len(got)-1].hp = *v.HC, *v.HP
+			}
+			if v.Cost != nil {
+				got[len(got)-1].cost = v.Cost.Total
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s from %s to %s: %v, want %v", tt.granularity, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
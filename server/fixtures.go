@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// Query and form parameters never written to fixtures
+var sensitiveKeys = map[string]bool{
+	"access_token":   true,
+	"token":          true,
+	"password":       true,
+	"username":       true,
+	"code":           true,
+	"code_verifier":  true,
+	"code_challenge": true,
+	"api_key":        true,
+	"apikey":         true,
+}
+
+// JSON fields never written to fixtures
+var sensitiveFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"token":         true,
+	"password":      true,
+	"username":      true,
+}
+
+// fixtureTransport records upstream exchanges to a fixtures directory, or
+// replays them offline. Requests are matched on method, host, path and
+// normalized query; request bodies are stored for reference only.
+type fixtureTransport struct {
+	dir    string
+	record bool
+	next   http.RoundTripper
+}
+
+// A recorded request/response pair
+type fixture struct {
+	Request struct {
+		Method string       `json:"method"`
+		URL    string       `json:"url"`
+		Header http.Header  `json:"header,omitempty"`
+		Body   *fixtureBody `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status int          `json:"status"`
+		Header http.Header  `json:"header,omitempty"`
+		Body   *fixtureBody `json:"body,omitempty"`
+	} `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Body stored as JSON when possible, as text otherwise
+type fixtureBody struct {
+	JSON json.RawMessage `json:"json,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// Route upstream requests through the fixtures directory. Mode is
+// "record", "replay", or empty to talk to upstream APIs directly.
+func UseFixtures(mode, dir string) {
+	if mode == "" {
+		return
+	}
+	for _, client := range []*http.Client{httpClient, httpNoRedirect} {
+		client.Transport = &fixtureTransport{
+			dir:    dir,
+			record: mode == "record",
+			next:   http.DefaultTransport,
+		}
+	}
+	log.Printf("[fixtures] %s mode, directory %s", mode, dir)
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := t.path(req)
+	if !t.record {
+		return t.replay(req, path)
+	}
+
+	var reqBody []byte
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		reqBody, _ = io.ReadAll(body)
+		body.Close()
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err := t.save(path, req, reqBody, resp, data); err != nil {
+		log.Printf("[fixtures] record %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	return resp, nil
+}
+
+// Serve a recorded response
+func (t *fixtureTransport) replay(req *http.Request, path string) (*http.Response, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for %s %s%s", req.Method, req.URL.Host, req.URL.Path)
+	}
+	var f fixture
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+
+	var data []byte
+	if b := f.Response.Body; b != nil {
+		data = []byte(b.Text)
+		if b.JSON != nil {
+			data = b.JSON
+		}
+	}
+	header := f.Response.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Response.Status, http.StatusText(f.Response.Status)),
+		StatusCode:    f.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// Write a sanitized fixture
+func (t *fixtureTransport) save(path string, req *http.Request, reqBody []byte, resp *http.Response, data []byte) error {
+	var f fixture
+	f.Request.Method = req.Method
+	f.Request.URL = sanitizeURL(req.URL)
+	f.Request.Header = sanitizeHeader(req.Header)
+	f.Request.Body = sanitizeBody(reqBody, req.Header.Get("Content-Type"))
+	f.Response.Status = resp.StatusCode
+	f.Response.Header = sanitizeHeader(resp.Header)
+	f.Response.Body = sanitizeBody(data, resp.Header.Get("Content-Type"))
+	f.RecordedAt = time.Now().UTC()
+
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw)
+}
+
+var fixtureSlug = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Fixture file of a request: <dir>/<host>/<method>_<path>[_<query hash>].json
+func (t *fixtureTransport) path(req *http.Request) string {
+	name := req.Method + "_" + strings.Trim(fixtureSlug.ReplaceAllString(req.URL.Path, "_"), "_")
+	if query := normalizeQuery(req.URL.Query()); query != "" {
+		sum := sha256.Sum256([]byte(query))
+		name += "_" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(t.dir, fixtureSlug.ReplaceAllString(req.URL.Host, "_"), name+".json")
+}
+
+// Query with sorted keys, secrets redacted and dates replaced by a
+// placeholder, so that replays match on any day
+func normalizeQuery(query url.Values) string {
+	norm := make(url.Values, len(query))
+	for key, values := range query {
+		for _, v := range values {
+			switch {
+			case sensitiveKeys[strings.ToLower(key)]:
+				v = redacted
+			case isDate(v):
+				v = "{date}"
+			}
+			norm.Add(key, v)
+		}
+	}
+	return norm.Encode()
+}
+
+// Check whether a value is a date or timestamp
+func isDate(v string) bool {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if _, err := time.Parse(layout, v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// URL with sensitive query parameters redacted
+func sanitizeURL(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	query := u.Query()
+	for key := range query {
+		if sensitiveKeys[strings.ToLower(key)] {
+			query.Set(key, redacted)
+		}
+	}
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
+
+// Headers with credentials redacted, keeping cookie names
+func sanitizeHeader(header http.Header) http.Header {
+	clean := make(http.Header, len(header))
+	for key, values := range header {
+		for _, v := range values {
+			switch strings.ToLower(key) {
+			case "set-cookie":
+				v = redactCookie(v)
+			case "cookie", "authorization":
+				v = redacted
+			case "location":
+				if u, err := url.Parse(v); err == nil {
+					v = sanitizeURL(u)
+				}
+			}
+			clean.Add(key, v)
+		}
+	}
+	return clean
+}
+
+// Redact the value of a Set-Cookie header, keeping its name and attributes
+func redactCookie(v string) string {
+	name, rest, _ := strings.Cut(v, "=")
+	_, attrs, found := strings.Cut(rest, ";")
+	if !found {
+		return name + "=" + redacted
+	}
+	return name + "=" + redacted + ";" + attrs
+}
+
+// Body with sensitive JSON fields or form values redacted
+func sanitizeBody(data []byte, contentType string) *fixtureBody {
+	if len(data) == 0 {
+		return nil
+	}
+	var v any
+	if json.Unmarshal(data, &v) == nil {
+		raw, err := json.Marshal(redactJSON(v))
+		if err == nil {
+			return &fixtureBody{JSON: raw}
+		}
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(data)); err == nil {
+			for key := range form {
+				if sensitiveKeys[strings.ToLower(key)] {
+					form.Set(key, redacted)
+				}
+			}
+			return &fixtureBody{Text: form.Encode()}
+		}
+	}
+	return &fixtureBody{Text: string(data)}
+}
+
+// Redact sensitive fields of a decoded JSON value
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if sensitiveFields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactJSON(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Recorded exchanges carry no secrets and replay offline for any date
+func TestFixturesRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/next?code=abc123")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, `{"access_token": "tok-1234", "days": [{"date": "2026-01-15", "color": "red"}]}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder := &http.Client{Transport: &fixtureTransport{dir: dir, record: true, next: http.DefaultTransport}}
+	replayer := &http.Client{Transport: &fixtureTransport{dir: dir}}
+
+	form := url.Values{"username": {"alice"}, "password": {"hunter2"}, "grant": {"password"}}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/days?start=2026-01-15&api_key=k3y", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer tok-1234")
+	resp, err := recorder.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "POST_api_days_*.json"))
+	if len(files) != 1 {
+		t.Fatalf("fixtures %v, want one", files)
+	}
+	raw, _ := os.ReadFile(files[0])
+	for _, secret := range []string{"s3cr3t", "tok-1234", "k3y", "alice", "hunter2", "abc123"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("fixture contains %q:\n%s", secret, raw)
+		}
+	}
+	if !strings.Contains(string(raw), "session=REDACTED; Path=/") {
+		t.Errorf("fixture lost the cookie name and attributes:\n%s", raw)
+	}
+
+	// Another day and key match the same fixture
+	req, _ = http.NewRequest(http.MethodPost, srv.URL+"/api/days?start=2026-02-01&api_key=other", nil)
+	resp, err = replayer.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("replayed %d %v", resp.StatusCode, resp.Header)
+	}
+	var got, want map[string]any
+	json.Unmarshal(replayed, &got)
+	json.Unmarshal(recorded, &want)
+	want["access_token"] = redacted
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed body %s, want %s with the token redacted", replayed, recorded)
+	}
+
+	// Requests never recorded fail instead of reaching upstream
+	for _, u := range []string{srv.URL + "/api/days?start=2026-01-15&extra=1", srv.URL + "/api/other"} {
+		req, _ = http.NewRequest(http.MethodPost, u, nil)
+		if _, err := replayer.Do(req); err == nil || !strings.Contains(err.Error(), "no fixture") {
+			t.Errorf("%s: %v, want a missing fixture error", u, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup deduplicates concurrent calls sharing the same key, so that
+// only one of them runs and all callers receive its result.
+type flightGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	val     V
+	err     error
+}
+
+// Run fn once per key among concurrent callers. The shared call is
+// cancelled once every caller has given up waiting on it, and callers
+// arriving after that start a new call.
+func (g *flightGroup[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*flightCall[V])
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[V]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(callCtx, key, call, fn)
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			g.forget(key, call)
+		}
+		g.mu.Unlock()
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// Execute a shared call and release its waiters
+func (g *flightGroup[K, V]) run(ctx context.Context, key K, call *flightCall[V], fn func(ctx context.Context) (V, error)) {
+	defer func() {
+		g.mu.Lock()
+		g.forget(key, call)
+		g.mu.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+
+	call.val, call.err = fn(ctx)
+}
+
+// Remove a call from the map unless a newer one replaced it. Called with
+// g.mu held.
+func (g *flightGroup[K, V]) forget(key K, call *flightCall[V]) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
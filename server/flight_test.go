@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Concurrent callers share one call, and a caller giving up does not cancel
+// it for the others
+func TestFlightShared(t *testing.T) {
+	var g flightGroup[string, int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, ctx.Err()
+	}
+
+	leaving, leave := context.WithCancel(context.Background())
+	left := make(chan error)
+	go func() {
+		_, err := g.Do(leaving, "k", fn)
+		left <- err
+	}()
+	eventually(t, "the first call", func() bool { return calls.Load() == 1 })
+
+	var wg sync.WaitGroup
+	results := make([]int, 4)
+	errs := make([]error, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = g.Do(context.Background(), "k", fn)
+		}(i)
+	}
+	eventually(t, "every caller to join", func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["k"] != nil && g.calls["k"].waiters == 5
+	})
+
+	leave()
+	if err := <-left; !errors.Is(err, context.Canceled) {
+		t.Errorf("caller giving up: %v, want context.Canceled", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+	for i := range results {
+		if results[i] != 42 || errs[i] != nil {
+			t.Errorf("caller %d: %d, %v", i, results[i], errs[i])
+		}
+	}
+}
+
+// The call is cancelled once every caller gave up, and a caller arriving
+// before it returns starts a new call instead of getting its cancellation
+func TestFlightAbandoned(t *testing.T) {
+	var g flightGroup[string, int]
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	abandoned := func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		close(cancelled)
+		<-release
+		return 0, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		g.Do(ctx, "k", abandoned)
+		close(done)
+	}()
+	var old *flightCall[int]
+	eventually(t, "the call to start", func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		old = g.calls["k"]
+		return old != nil
+	})
+	cancel()
+	<-done
+	<-cancelled
+
+	// The abandoned call has not returned yet
+	v, err := g.Do(context.Background(), "k", func(ctx context.Context) (int, error) {
+		return 7, ctx.Err()
+	})
+	if v != 7 || err != nil {
+		t.Errorf("late caller: %d, %v, want a new call", v, err)
+	}
+
+	// The abandoned call returning leaves newer calls in place
+	blocked := make(chan struct{})
+	finish := make(chan struct{})
+	go g.Do(context.Background(), "k", func(context.Context) (int, error) {
+		close(blocked)
+		<-finish
+		return 0, nil
+	})
+	<-blocked
+	close(release)
+	<-old.done
+	g.mu.Lock()
+	current := g.calls["k"]
+	g.mu.Unlock()
+	if current == nil || current == old {
+		t.Error("abandoned call returning removed the newer call")
+	}
+	close(finish)
+}
@@ -2,4 +2,14 @@ module strasboard/server
 
 go 1.21
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/joho/godotenv v1.5.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+)
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health tracks the outcome of each source refresh and whether the
+// initial pre-warm has completed.
+type Health struct {
+	cache   *Cache
+	sources *SourceSet
+
+	mu       sync.RWMutex
+	ready    bool
+	required map[string]bool
+	history  map[string]*sourceHistory
+}
+
+type sourceHistory struct {
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Health report returned by /health
+type HealthReport struct {
+	Status    string                  `json:"status"`
+	Ready     bool                    `json:"ready"`
+	Timestamp string                  `json:"timestamp"`
+	Sources   map[string]SourceHealth `json:"sources"`
+}
+
+type SourceHealth struct {
+	Status      string  `json:"status"`
+	Required    bool    `json:"required"`
+	Degraded    bool    `json:"degraded"`
+	LastSuccess *string `json:"last_success,omitempty"`
+	LastError   string  `json:"last_error,omitempty"`
+	LastErrorAt *string `json:"last_error_at,omitempty"`
+	ExpiresIn   int     `json:"expires_in"`
+}
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+	healthPending  = "pending"
+)
+
+// Create a health tracker. If required is empty, the server is only
+// reported down when every source is down.
+func NewHealth(cache *Cache, sources *SourceSet, required []string) *Health {
+	h := &Health{
+		cache:   cache,
+		sources: sources,
+		history: make(map[string]*sourceHistory),
+	}
+	h.SetRequired(required)
+	return h
+}
+
+// Replace the list of required sources
+func (h *Health) SetRequired(required []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.required = make(map[string]bool)
+	for _, name := range required {
+		h.required[name] = true
+	}
+}
+
+// Cache listener recording successes and errors
+func (h *Health) OnSet(key string, _, resp *Response) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist := h.history[key]
+	if hist == nil {
+		hist = &sourceHistory{}
+		h.history[key] = hist
+	}
+	if resp.Error == "" {
+		hist.lastSuccess = clock.Now()
+	} else {
+		hist.lastError = resp.Error
+		hist.lastErrorAt = clock.Now()
+	}
+}
+
+// Mark the pre-warm as completed
+func (h *Health) SetReady() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = true
+}
+
+// Check whether the pre-warm has completed
+func (h *Health) Ready() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.ready
+}
+
+// Build the health report for all sources
+func (h *Health) Report() *HealthReport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	report := &HealthReport{
+		Status:    healthOK,
+		Ready:     h.ready,
+		Timestamp: clock.Now().UTC().Format(time.RFC3339),
+		Sources:   make(map[string]SourceHealth),
+	}
+
+	names := sortedKeys(h.sources.All())
+	down, requiredDown := 0, false
+	for _, name := range names {
+		sh := h.sourceHealth(name)
+		report.Sources[name] = sh
+
+		if sh.Status != healthOK {
+			report.Status = healthDegraded
+		}
+		if sh.Status == healthDown {
+			down++
+			requiredDown = requiredDown || sh.Required
+		}
+	}
+	if requiredDown || (len(h.required) == 0 && len(names) > 0 && down == len(names)) {
+		report.Status = healthDown
+	}
+	return report
+}
+
+// Health of a single source from its cached response and history
+func (h *Health) sourceHealth(name string) SourceHealth {
+	hist := h.history[name]
+	if hist == nil {
+		hist = &sourceHistory{}
+	}
+	sh := SourceHealth{
+		Status:    healthPending,
+		Required:  h.required[name],
+		LastError: hist.lastError,
+	}
+	if !hist.lastSuccess.IsZero() {
+		ts := hist.lastSuccess.UTC().Format(time.RFC3339)
+		sh.LastSuccess = &ts
+	}
+	if !hist.lastErrorAt.IsZero() {
+		ts := hist.lastErrorAt.UTC().Format(time.RFC3339)
+		sh.LastErrorAt = &ts
+	}
+
+	resp := h.cache.Peek(name)
+	if resp == nil {
+		return sh
+	}
+	if expiresIn := resp.ExpiresAt.Sub(clock.Now()); expiresIn > 0 {
+		sh.ExpiresIn = int(expiresIn.Seconds())
+	}
+	switch {
+	case resp.Error == "":
+		sh.Status = healthOK
+	case resp.Data != nil:
+		sh.Status = healthDegraded
+		sh.Degraded = true
+	default:
+		sh.Status = healthDown
+	}
+	return sh
+}
+
+// HTTP handler for the detailed health report
+func (h *Health) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.Report()
+		if report.Status == healthDown {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, report)
+	}
+}
+
+// HTTP handler succeeding once the pre-warm has completed
+func (h *Health) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := "ready"
+		if !h.Ready() {
+			status = "starting"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, map[string]string{
+			"status":    status,
+			"timestamp": clock.Now().UTC().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthReport(t *testing.T) {
+	fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	sources := NewSourceSet(map[string]Source{
+		"tempo":     &testSource{name: "tempo"},
+		"weather":   &testSource{name: "weather"},
+		"transport": &testSource{name: "transport"},
+	})
+
+	// Set a response giving each source the wanted status, none if pending
+	setAll := func(cache *Cache, tempo, weather, transport string) {
+		for name, status := range map[string]string{"tempo": tempo, "weather": weather, "transport": transport} {
+			switch status {
+			case healthOK:
+				cache.Set(name, NewResponse("data", time.Hour), time.Hour)
+			case healthDegraded:
+				cache.Set(name, DegradedResponse(NewResponse("old", time.Hour), ErrorResponse("timeout", time.Minute)), time.Hour)
+			case healthDown:
+				cache.Set(name, ErrorResponse("timeout", time.Minute), time.Hour)
+			}
+		}
+	}
+
+	tests := []struct {
+		name                      string
+		required                  []string
+		tempo, weather, transport string
+		want                      string
+	}{
+		{"all ok", nil, healthOK, healthOK, healthOK, healthOK},
+		{"not fetched yet", nil, healthOK, healthOK, healthPending, healthDegraded},
+		{"serving backup data", nil, healthOK, healthDegraded, healthOK, healthDegraded},
+		{"one down", nil, healthOK, healthOK, healthDown, healthDegraded},
+		{"all down", nil, healthDown, healthDown, healthDown, healthDown},
+		{"required down", []string{"tempo"}, healthDown, healthOK, healthOK, healthDown},
+		{"optional down", []string{"tempo"}, healthOK, healthDown, healthDown, healthDegraded},
+		{"required, all down but pending", []string{"tempo"}, healthPending, healthDown, healthDown, healthDegraded},
+	}
+	for _, tt := range tests {
+		cache := NewCache()
+		h := NewHealth(cache, sources, tt.required)
+		cache.OnSet(h.OnSet)
+		setAll(cache, tt.tempo, tt.weather, tt.transport)
+
+		report := h.Report()
+		if report.Status != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, report.Status, tt.want)
+		}
+		for name, status := range map[string]string{"tempo": tt.tempo, "weather": tt.weather, "transport": tt.transport} {
+			if got := report.Sources[name].Status; got != status {
+				t.Errorf("%s: %s %s, want %s", tt.name, name, got, status)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		h.Handler()(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		if down := tt.want == healthDown; down != (w.Code == http.StatusServiceUnavailable) {
+			t.Errorf("%s: /health returned %d", tt.name, w.Code)
+		}
+	}
+}
+
+// Sources keep their last success and error across refreshes
+func TestHealthHistory(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	cache := NewCache()
+	h := NewHealth(cache, NewSourceSet(map[string]Source{"tempo": &testSource{name: "tempo"}}), nil)
+	cache.OnSet(h.OnSet)
+
+	cache.Set("tempo", NewResponse("red", time.Hour), time.Hour)
+	c.Advance(time.Hour)
+	cache.Set("tempo", ErrorResponse("timeout", time.Minute), time.Hour)
+
+	sh := h.Report().Sources["tempo"]
+	if sh.LastSuccess == nil || *sh.LastSuccess != "2026-01-15T11:00:00Z" {
+		t.Errorf("last success %v", sh.LastSuccess)
+	}
+	if sh.LastError != "timeout" || sh.LastErrorAt == nil || *sh.LastErrorAt != "2026-01-15T12:00:00Z" {
+		t.Errorf("last error %q at %v", sh.LastError, sh.LastErrorAt)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	h := NewHealth(NewCache(), NewSourceSet(nil), nil)
+	get := func() (int, string) {
+		w := httptest.NewRecorder()
+		h.ReadyHandler()(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var body struct{ Status string }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body.Status
+	}
+
+	if code, status := get(); code != http.StatusServiceUnavailable || status != "starting" {
+		t.Errorf("before pre-warm: %d %s", code, status)
+	}
+	h.SetReady()
+	if code, status := get(); code != http.StatusOK || status != "ready" {
+		t.Errorf("after pre-warm: %d %s", code, status)
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Raw readings kept for replay and fine-grained queries
+const historyRawRetention = 7 * 24 * time.Hour
+
+// Downsampling tier of the temperature history. Buckets of a tier are
+// written once closed, i.e. when a reading falls in a later bucket.
+type historyTier struct {
+	name      string
+	step      time.Duration // 0 for calendar days
+	retention time.Duration // 0 to keep forever
+	partition string        // time layout of file names
+}
+
+var historyTiers = []historyTier{
+	{name: "5m", step: 5 * time.Minute, retention: 90 * 24 * time.Hour, partition: "2006-01"},
+	{name: "1h", step: time.Hour, partition: "2006"},
+	{name: "1d", partition: "2006"},
+}
+
+var historyRaw = historyTier{name: "raw", retention: historyRawRetention, partition: "2006-01-02"}
+
+// Start of the bucket containing t
+func (t historyTier) start(at time.Time) time.Time {
+	if t.step == 0 {
+		local := at.In(clock.Now().Location())
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	}
+	return at.Truncate(t.step)
+}
+
+// History persists every accepted sensor reading to CSV files under a
+// directory, one subdirectory per resolution: raw readings for a week,
+// 5-minute buckets for 90 days, hourly and daily buckets forever.
+type History struct {
+	dir string
+
+	mu        sync.Mutex
+	open      map[string]map[string]*historyBucket // tier, location
+	lastPrune time.Time
+}
+
+type historyBucket struct {
+	start     time.Time
+	location  string
+	count     int
+	temp, hum historyAgg
+}
+
+type historyAgg struct {
+	min, max, sum float64
+}
+
+func (a *historyAgg) add(v float64, first bool) {
+	if first || v < a.min {
+		a.min = v
+	}
+	if first || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+}
+
+// HistoryStats summarizes the values of a bucket
+type HistoryStats struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+type HistoryPoint struct {
+	Time        string       `json:"time"`
+	Count       int          `json:"count"`
+	Temperature HistoryStats `json:"temperature"`
+	Humidity    HistoryStats `json:"humidity"`
+}
+
+type HistorySeries struct {
+	Location string         `json:"location"`
+	Points   []HistoryPoint `json:"points"`
+}
+
+// HistoryData is returned by /api/temperature/history
+type HistoryData struct {
+	Resolution string          `json:"resolution"`
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Series     []HistorySeries `json:"series"`
+}
+
+// Open the history in dir, replaying recent raw readings into the buckets
+// that were still open when the server stopped
+func NewHistory(dir string) (*History, error) {
+	h := &History{dir: dir, open: make(map[string]map[string]*historyBucket)}
+	for _, tier := range append([]historyTier{historyRaw}, historyTiers...) {
+		if err := os.MkdirAll(filepath.Join(dir, tier.name), 0o755); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := clock.Now()
+	closed := make(map[string]map[string]time.Time)
+	for _, tier := range historyTiers {
+		h.open[tier.name] = make(map[string]*historyBucket)
+		last, err := h.lastClosed(tier)
+		if err != nil {
+			return nil, err
+		}
+		// The current bucket, written by Close, is reopened with its
+		// readings; its complete record supersedes that one once closed
+		for location, at := range last {
+			if at.Equal(tier.start(now)) {
+				last[location] = at.Add(-time.Nanosecond)
+			}
+		}
+		closed[tier.name] = last
+	}
+
+	replayed := 0
+	err := h.scan(historyRaw, replayStart(closed, now), now, func(rec []string) {
+		at, location, temp, hum, ok := parseRawRecord(rec)
+		if !ok {
+			return
+		}
+		for _, tier := range historyTiers {
+			if last, ok := closed[tier.name][location]; ok && !tier.start(at).After(last) {
+				continue
+			}
+			h.aggregate(tier, at, location, temp, hum)
+		}
+		replayed++
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[history] opened %s, %d recent readings replayed", dir, replayed)
+	return h, nil
+}
+
+// Start of the raw readings to replay: the oldest last written bucket of
+// any tier and location, as readings after it may belong to a bucket that
+// was still open, within raw retention. A location missing from a tier has
+// all of its retained readings replayed.
+func replayStart(closed map[string]map[string]time.Time, now time.Time) time.Time {
+	from := now.Add(-historyRawRetention)
+	locations := make(map[string]bool)
+	for _, last := range closed {
+		for location := range last {
+			locations[location] = true
+		}
+	}
+	if len(locations) == 0 {
+		return from
+	}
+
+	var oldest time.Time
+	for _, last := range closed {
+		for location := range locations {
+			at, ok := last[location]
+			if !ok {
+				return from
+			}
+			if oldest.IsZero() || at.Before(oldest) {
+				oldest = at
+			}
+		}
+	}
+	if oldest.Before(from) {
+		return from
+	}
+	return oldest
+}
+
+// Record an accepted reading
+func (h *History) Add(p TemperaturePayload) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := clock.Now()
+	rec := []string{now.UTC().Format(time.RFC3339), p.Location, formatValue(p.Temperature), formatValue(p.Humidity)}
+	if err := h.append(historyRaw, now, rec); err != nil {
+		log.Printf("[history] %v", err)
+	}
+	for _, tier := range historyTiers {
+		h.aggregate(tier, now, p.Location, p.Temperature, p.Humidity)
+	}
+
+	if now.Sub(h.lastPrune) >= 24*time.Hour {
+		h.prune(now)
+		h.lastPrune = now
+	}
+}
+
+// Write the buckets still being filled, so that they survive a restart
+// beyond raw retention
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var errs []error
+	for _, tier := range historyTiers {
+		for _, b := range h.open[tier.name] {
+			if err := h.append(tier, b.start, b.record()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		h.open[tier.name] = make(map[string]*historyBucket)
+	}
+	return errors.Join(errs...)
+}
+
+// Add a reading to the open bucket of a tier, writing the previous bucket
+// once the reading falls outside of it. Called with h.mu held.
+func (h *History) aggregate(tier historyTier, at time.Time, location string, temp, hum float64) {
+	start := tier.start(at)
+	b := h.open[tier.name][location]
+	if b != nil && !b.start.Equal(start) {
+		if err := h.append(tier, b.start, b.record()); err != nil {
+			log.Printf("[history] %v", err)
+		}
+		b = nil
+	}
+	if b == nil {
+		b = &historyBucket{start: start, location: location}
+		h.open[tier.name][location] = b
+	}
+	b.temp.add(temp, b.count == 0)
+	b.hum.add(hum, b.count == 0)
+	b.count++
+}
+
+// Append a record to the partition file of a tier
+func (h *History) append(tier historyTier, at time.Time, rec []string) error {
+	path := filepath.Join(h.dir, tier.name, at.In(clock.Now().Location()).Format(tier.partition)+".csv")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(rec)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// Delete partitions older than the retention of their tier.
+// Called with h.mu held.
+func (h *History) prune(now time.Time) {
+	for _, tier := range append([]historyTier{historyRaw}, historyTiers...) {
+		if tier.retention == 0 {
+			continue
+		}
+		oldest := now.Add(-tier.retention).In(now.Location()).Format(tier.partition)
+		for _, name := range h.partitions(tier) {
+			if strings.TrimSuffix(name, ".csv") < oldest {
+				os.Remove(filepath.Join(h.dir, tier.name, name))
+			}
+		}
+	}
+}
+
+// Partition file names of a tier, oldest first
+func (h *History) partitions(tier historyTier) []string {
+	entries, err := os.ReadDir(filepath.Join(h.dir, tier.name))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".csv") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Call fn for every record in the partitions of a tier overlapping [from, to]
+func (h *History) scan(tier historyTier, from, to time.Time, fn func(rec []string)) error {
+	loc := clock.Now().Location()
+	first := from.In(loc).Format(tier.partition)
+	last := to.In(loc).Format(tier.partition)
+
+	for _, name := range h.partitions(tier) {
+		if p := strings.TrimSuffix(name, ".csv"); p < first || p > last {
+			continue
+		}
+		path := filepath.Join(h.dir, tier.name, name)
+		// Skip partitions pruned meanwhile
+		if err := readRecords(path, fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("history: read %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Start of the last written bucket of a tier, per location, looking at
+// the two latest partitions
+func (h *History) lastClosed(tier historyTier) (map[string]time.Time, error) {
+	last := make(map[string]time.Time)
+	names := h.partitions(tier)
+	for _, name := range names[max(len(names)-2, 0):] {
+		path := filepath.Join(h.dir, tier.name, name)
+		err := readRecords(path, func(rec []string) {
+			if p, ok := parseBucketRecord(rec); ok {
+				at, _ := time.Parse(time.RFC3339, p.Time)
+				if at.After(last[rec[1]]) {
+					last[rec[1]] = at
+				}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("history: read %s: %w", path, err)
+		}
+	}
+	return last, nil
+}
+
+// Read all CSV records of a file, skipping malformed lines such as a
+// line cut short by a crash
+func readRecords(path string, fn func(rec []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fn(rec)
+	}
+}
+
+// Query the history between from and to at a resolution ("raw" or a tier
+// name), optionally for a single location. Files are read without holding
+// the lock, records are appended whole.
+func (h *History) Query(resolution string, from, to time.Time, location string) (*HistoryData, error) {
+	series := make(map[string][]HistoryPoint)
+	keep := func(at time.Time, loc string) bool {
+		return (location == "" || loc == location) && !at.Before(from) && at.Before(to)
+	}
+
+	if resolution == historyRaw.name {
+		err := h.scan(historyRaw, from, to, func(rec []string) {
+			at, loc, temp, hum, ok := parseRawRecord(rec)
+			if ok && keep(at, loc) {
+				series[loc] = append(series[loc], HistoryPoint{
+					Time:        at.Format(time.RFC3339),
+					Count:       1,
+					Temperature: HistoryStats{Min: temp, Avg: temp, Max: temp},
+					Humidity:    HistoryStats{Min: hum, Avg: hum, Max: hum},
+				})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var tier historyTier
+		for _, t := range historyTiers {
+			if t.name == resolution {
+				tier = t
+			}
+		}
+		if tier.name == "" {
+			return nil, fmt.Errorf("unknown resolution %q", resolution)
+		}
+		// Buckets are matched on their start, so include the one containing from
+		from = tier.start(from)
+
+		// A bucket may be found twice: written by Close then reopened, or
+		// closed while the files are read. The one with most readings is
+		// the latest.
+		buckets := make(map[string]map[string]HistoryPoint) // location, start
+		add := func(loc string, p HistoryPoint) {
+			if buckets[loc] == nil {
+				buckets[loc] = make(map[string]HistoryPoint)
+			}
+			if prev, ok := buckets[loc][p.Time]; !ok || p.Count > prev.Count {
+				buckets[loc][p.Time] = p
+			}
+		}
+
+		// Buckets still being filled
+		h.mu.Lock()
+		for loc, b := range h.open[tier.name] {
+			if keep(b.start, loc) {
+				add(loc, b.point())
+			}
+		}
+		h.mu.Unlock()
+
+		err := h.scan(tier, from, to, func(rec []string) {
+			p, ok := parseBucketRecord(rec)
+			at, _ := time.Parse(time.RFC3339, p.Time)
+			if ok && keep(at, rec[1]) {
+				add(rec[1], p)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		for loc, points := range buckets {
+			for _, p := range points {
+				series[loc] = append(series[loc], p)
+			}
+		}
+	}
+
+	data := &HistoryData{
+		Resolution: resolution,
+		From:       from.UTC().Format(time.RFC3339),
+		To:         to.UTC().Format(time.RFC3339),
+		Series:     []HistorySeries{},
+	}
+	for _, loc := range sortedKeys(series) {
+		points := series[loc]
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+		data.Series = append(data.Series, HistorySeries{Location: loc, Points: points})
+	}
+	return data, nil
+}
+
+// Pick the resolution of a query from its span
+func autoResolution(span time.Duration) string {
+	switch {
+	case span <= 2*24*time.Hour:
+		return "5m"
+	case span <= 62*24*time.Hour:
+		return "1h"
+	default:
+		return "1d"
+	}
+}
+
+// HTTP handler for /api/temperature/history?from=&to=&resolution=&location=.
+// from and to are RFC 3339 times or dates, the last 24 hours by default.
+func (h *History) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		to := clock.Now()
+		if v := q.Get("to"); v != "" {
+			t, err := parseHistoryTime(v)
+			if err != nil {
+				writeResponse(w, r, ErrorResponse("invalid to", time.Minute))
+				return
+			}
+			to = t
+		}
+		from := to.Add(-24 * time.Hour)
+		if v := q.Get("from"); v != "" {
+			t, err := parseHistoryTime(v)
+			if err != nil {
+				writeResponse(w, r, ErrorResponse("invalid from", time.Minute))
+				return
+			}
+			from = t
+		}
+		if !from.Before(to) {
+			writeResponse(w, r, ErrorResponse("from must be before to", time.Minute))
+			return
+		}
+
+		resolution := q.Get("resolution")
+		if resolution == "" || resolution == "auto" {
+			resolution = autoResolution(to.Sub(from))
+		}
+
+		data, err := h.Query(resolution, from, to, q.Get("location"))
+		if err != nil {
+			writeResponse(w, r, ErrorResponse(err.Error(), time.Minute))
+			return
+		}
+		writeResponse(w, r, NewResponse(data, time.Minute))
+	}
+}
+
+// Parse an RFC 3339 time or a local date
+func parseHistoryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, v, clock.Now().Location())
+}
+
+// Record of a closed bucket: start, location, count, then min, avg and max
+// of temperature and humidity
+func (b *historyBucket) record() []string {
+	p := b.point()
+	return []string{
+		p.Time, b.location, strconv.Itoa(p.Count),
+		formatValue(p.Temperature.Min), formatValue(p.Temperature.Avg), formatValue(p.Temperature.Max),
+		formatValue(p.Humidity.Min), formatValue(p.Humidity.Avg), formatValue(p.Humidity.Max),
+	}
+}
+
+func (b *historyBucket) point() HistoryPoint {
+	n := float64(b.count)
+	return HistoryPoint{
+		Time:        b.start.UTC().Format(time.RFC3339),
+		Count:       b.count,
+		Temperature: HistoryStats{Min: b.temp.min, Avg: round2(b.temp.sum / n), Max: b.temp.max},
+		Humidity:    HistoryStats{Min: b.hum.min, Avg: round2(b.hum.sum / n), Max: b.hum.max},
+	}
+}
+
+func parseBucketRecord(rec []string) (HistoryPoint, bool) {
+	if len(rec) != 9 {
+		return HistoryPoint{}, false
+	}
+	var vals [6]float64
+	for i := range vals {
+		v, err := strconv.ParseFloat(rec[3+i], 64)
+		if err != nil {
+			return HistoryPoint{}, false
+		}
+		vals[i] = v
+	}
+	count, err := strconv.Atoi(rec[2])
+	if _, terr := time.Parse(time.RFC3339, rec[0]); err != nil || terr != nil {
+		return HistoryPoint{}, false
+	}
+	return HistoryPoint{
+		Time:        rec[0],
+		Count:       count,
+		Temperature: HistoryStats{Min: vals[0], Avg: vals[1], Max: vals[2]},
+		Humidity:    HistoryStats{Min: vals[3], Avg: vals[4], Max: vals[5]},
+	}, true
+}
+
+// Raw record: time, location, temperature, humidity
+func parseRawRecord(rec []string) (at time.Time, location string, temp, hum float64, ok bool) {
+	if len(rec) != 4 {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, rec[0])
+	if err != nil {
+		return
+	}
+	if temp, err = strconv.ParseFloat(rec[2], 64); err != nil {
+		return
+	}
+	if hum, err = strconv.ParseFloat(rec[3], 64); err != nil {
+		return
+	}
+	return at, rec[1], temp, hum, true
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Buckets still open when the server stops are rebuilt from raw readings
+// on the next start, even after days of downtime, without counting
+// readings of written buckets twice
+func TestHistoryReplayAfterDowntime(t *testing.T) {
+	dir := t.TempDir()
+	c := fakeClock(t, time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC))
+
+	h, err := NewHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Add(TemperaturePayload{Location: "living", Temperature: 20, Humidity: 40})
+	c.Advance(time.Hour)
+	h.Add(TemperaturePayload{Location: "living", Temperature: 22, Humidity: 50})
+
+	c.Advance(3 * 24 * time.Hour)
+	h, err = NewHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Add(TemperaturePayload{Location: "living", Temperature: 19, Humidity: 45})
+
+	from, to := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC), clock.Now()
+	tests := []struct {
+		resolution, time string
+		count            int
+		avg              float64
+	}{
+		{"1d", "2026-01-15T00:00:00Z", 2, 21},
+		{"1h", "2026-01-15T10:00:00Z", 1, 20},
+		{"1h", "2026-01-15T11:00:00Z", 1, 22},
+	}
+	for _, tt := range tests {
+		data, err := h.Query(tt.resolution, from, to, "living")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var point *HistoryPoint
+		for _, s := range data.Series {
+			for i := range s.Points {
+				if s.Points[i].Time == tt.time {
+					point = &s.Points[i]
+				}
+			}
+		}
+		if point == nil {
+			t.Errorf("%s %s: bucket missing", tt.resolution, tt.time)
+			continue
+		}
+		if point.Count != tt.count || point.Temperature.Avg != tt.avg {
+			t.Errorf("%s %s: count %d avg %v, want %d and %v", tt.resolution, tt.time, point.Count, point.Temperature.Avg, tt.count, tt.avg)
+		}
+	}
+}
+
+func TestReplayStart(t *testing.T) {
+	now := time.Date(2026, 1, 20, 12, 0, 0, 0, time.UTC)
+	retention := now.Add(-historyRawRetention)
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		closed map[string]map[string]time.Time
+		want   time.Time
+	}{
+		{"nothing written", map[string]map[string]time.Time{"5m": {}, "1h": {}, "1d": {}}, retention},
+		{"oldest bucket", map[string]map[string]time.Time{
+			"5m": {"a": now.Add(-5 * time.Minute)},
+			"1h": {"a": now.Add(-time.Hour)},
+			"1d": {"a": day(19)},
+		}, day(19)},
+		{"beyond retention", map[string]map[string]time.Time{
+			"5m": {"a": day(2)}, "1h": {"a": day(2)}, "1d": {"a": day(1)},
+		}, retention},
+		{"location missing from a tier", map[string]map[string]time.Time{
+			"5m": {"a": now, "b": now}, "1h": {"a": now, "b": now}, "1d": {"a": day(19)},
+		}, retention},
+	}
+	for _, tt := range tests {
+		if got := replayStart(tt.closed, now); !got.Equal(tt.want) {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Find the point of a bucket in a query
+func historyPoint(t *testing.T, h *History, resolution string, from, to time.Time, at string) (HistoryPoint, int) {
+	t.Helper()
+	data, err := h.Query(resolution, from, to, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var point HistoryPoint
+	found := 0
+	for _, s := range data.Series {
+		for _, p := range s.Points {
+			if p.Time == at {
+				point = p
+				found++
+			}
+		}
+	}
+	return point, found
+}
+
+// Close writes the open buckets, which are reopened by a restart within
+// them and kept by a restart beyond raw retention
+func TestHistoryClose(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	c := fakeClock(t, start)
+	bucket := "2026-01-15T10:00:00Z"
+
+	h, err := NewHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Add(TemperaturePayload{Location: "living", Temperature: 20, Humidity: 40})
+	c.Advance(time.Minute)
+	h.Add(TemperaturePayload{Location: "living", Temperature: 22, Humidity: 40})
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Advance(time.Minute)
+	if h, err = NewHistory(dir); err != nil {
+		t.Fatal(err)
+	}
+	h.Add(TemperaturePayload{Location: "living", Temperature: 24, Humidity: 40})
+	for _, closed := range []bool{false, true} {
+		if closed {
+			c.Advance(5 * time.Minute)
+			h.Add(TemperaturePayload{Location: "living", Temperature: 18, Humidity: 40})
+		}
+		p, found := historyPoint(t, h, "5m", start, clock.Now(), bucket)
+		if found != 1 || p.Count != 3 || p.Temperature.Avg != 22 {
+			t.Errorf("closed %v: %d points, count %d avg %v, want 1 with 3 readings averaging 22", closed, found, p.Count, p.Temperature.Avg)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Advance(10 * 24 * time.Hour)
+	if h, err = NewHistory(dir); err != nil {
+		t.Fatal(err)
+	}
+	if p, found := historyPoint(t, h, "1d", start, clock.Now(), "2026-01-15T00:00:00Z"); found != 1 || p.Count != 4 {
+		t.Errorf("day after raw retention: %d points, count %d, want 1 with 4 readings", found, p.Count)
+	}
+}
+
+// Queries read the files while readings are added
+func TestHistoryQueryConcurrent(t *testing.T) {
+	c := fakeClock(t, time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC))
+	h, err := NewHistory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			h.Add(TemperaturePayload{Location: "living", Temperature: 20, Humidity: 40})
+			c.Advance(time.Minute)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := h.Query("5m", time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC), clock.Now().Add(time.Hour), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	data, err := h.Query("5m", time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC), clock.Now(), "living")
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, p := range data.Series[0].Points {
+		total += p.Count
+	}
+	if total != 200 {
+		t.Errorf("%d readings in 5-minute buckets, want 200", total)
+	}
+}
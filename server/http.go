@@ -2,14 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
+const (
+	httpMaxAttempts     = 3
+	httpRetryBase       = 500 * time.Millisecond
+	httpRetryMaxBackoff = 5 * time.Second
+	httpRetryMaxWait    = 30 * time.Second
+)
+
 var (
 	httpClient     = &http.Client{Timeout: 10 * time.Second}
 	httpNoRedirect = &http.Client{
@@ -19,12 +29,12 @@ var (
 )
 
 // Perform a GET request and decode the JSON response
-func GetJSON(baseURL string, query url.Values, headers http.Header, cookies []*http.Cookie, dest any, errCheck func([]byte) error) (*http.Response, error) {
-	return request("GET", buildURL(baseURL, query), nil, "", headers, cookies, true, dest, errCheck)
+func GetJSON(ctx context.Context, baseURL string, query url.Values, headers http.Header, cookies []*http.Cookie, dest any, errCheck func([]byte) error) (*http.Response, error) {
+	return request(ctx, "GET", buildURL(baseURL, query), nil, "", headers, cookies, true, dest, errCheck)
 }
 
 // Perform a POST request with JSON payload and decode the response
-func PostJSON(reqURL string, payload any, headers http.Header, cookies []*http.Cookie, dest any, errCheck func([]byte) error) (*http.Response, error) {
+func PostJSON(ctx context.Context, reqURL string, payload any, headers http.Header, cookies []*http.Cookie, dest any, errCheck func([]byte) error) (*http.Response, error) {
 	var body []byte
 	if payload != nil {
 		var err error
@@ -33,29 +43,102 @@ func PostJSON(reqURL string, payload any, headers http.Header, cookies []*http.C
 			return nil, fmt.Errorf("failed to encode payload: %w", err)
 		}
 	}
-	return request("POST", reqURL, body, "application/json", headers, cookies, true, dest, errCheck)
+	return request(ctx, "POST", reqURL, body, "application/json", headers, cookies, true, dest, errCheck)
 }
 
 // Perform a POST request with form data and decode the response
-func PostForm(reqURL string, params url.Values, headers http.Header, cookies []*http.Cookie, dest any, errCheck func([]byte) error) (*http.Response, error) {
-	return request("POST", reqURL, []byte(params.Encode()), "application/x-www-form-urlencoded", headers, cookies, true, dest, errCheck)
+func PostForm(ctx context.Context, reqURL string, params url.Values, headers http.Header, cookies []*http.Cookie, dest any, errCheck func([]byte) error) (*http.Response, error) {
+	return request(ctx, "POST", reqURL, []byte(params.Encode()), "application/x-www-form-urlencoded", headers, cookies, true, dest, errCheck)
 }
 
 // Perform a GET request without following redirects
-func GetRedirect(baseURL string, query url.Values, headers http.Header, cookies []*http.Cookie) (*http.Response, error) {
-	return request("GET", buildURL(baseURL, query), nil, "", headers, cookies, false, nil, nil)
+func GetRedirect(ctx context.Context, baseURL string, query url.Values, headers http.Header, cookies []*http.Cookie) (*http.Response, error) {
+	return request(ctx, "GET", buildURL(baseURL, query), nil, "", headers, cookies, false, nil, nil)
 }
 
-// Generic HTTP request function
-func request(method, reqURL string, body []byte, contentType string, headers http.Header, cookies []*http.Cookie, follow bool, dest any, errCheck func([]byte) error) (*http.Response, error) {
+// Generic HTTP request function, with retries for idempotent requests
+// and a circuit breaker per upstream host
+func request(ctx context.Context, method, reqURL string, body []byte, contentType string, headers http.Header, cookies []*http.Cookie, follow bool, dest any, errCheck func([]byte) error) (*http.Response, error) {
+	parsed, err := url.Parse(reqURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	breaker := breakers.get(parsed.Host)
+	if err := breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	attempts := 1
+	if method == http.MethodGet {
+		attempts = httpMaxAttempts
+	}
+
+	var resp *http.Response
+	var data []byte
+	for attempt := 1; ; attempt++ {
+		resp, data, err = send(ctx, method, reqURL, body, contentType, headers, cookies, follow)
+
+		failed := err != nil || resp.StatusCode >= 500
+		if !failed || attempt >= attempts || ctx.Err() != nil {
+			break
+		}
+		wait := retryWait(attempt, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+
+	// Caller cancellation says nothing about the upstream health
+	if err != nil && ctx.Err() == context.Canceled {
+		breaker.release()
+		return nil, err
+	}
+	if err != nil || resp.StatusCode >= 500 {
+		breaker.failure()
+	} else {
+		breaker.success()
+	}
+
+	if err != nil {
+		return resp, &upstreamError{host: parsed.Host, err: err}
+	}
+	if !follow && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return resp, nil
+	}
+
+	if resp.StatusCode >= 400 {
+		return resp, &upstreamError{host: parsed.Host, err: fmt.Errorf("server returned %d: %s", resp.StatusCode, truncate(data, 100))}
+	}
+
+	if errCheck != nil {
+		if err := errCheck(data); err != nil {
+			return resp, err
+		}
+	}
+
+	if dest != nil {
+		if err := json.Unmarshal(data, dest); err != nil {
+			return resp, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return resp, nil
+}
+
+// Send a single HTTP request and read the response body
+func send(ctx context.Context, method, reqURL string, body []byte, contentType string, headers http.Header, cookies []*http.Cookie, follow bool) (*http.Response, []byte, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		bodyReader = bytes.NewReader(body)
 	}
 
-	req, err := http.NewRequest(method, reqURL, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bodyReader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Accept", "application/json")
@@ -74,38 +157,41 @@ func request(method, reqURL string, body []byte, contentType string, headers htt
 		client = httpNoRedirect
 	}
 
+	start := time.Now()
 	resp, err := client.Do(req)
+	metricUpstreamLatency.ObserveSince(start, req.URL.Host)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		metricUpstreamResponses.Inc(req.URL.Host, "error")
+		return nil, nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	metricUpstreamResponses.Inc(req.URL.Host, strconv.Itoa(resp.StatusCode))
 
 	if !follow && resp.StatusCode >= 300 && resp.StatusCode < 400 {
-		return resp, nil
+		return resp, nil, nil
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return resp, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode >= 400 {
-		return resp, fmt.Errorf("server returned %d: %s", resp.StatusCode, truncate(data, 100))
-	}
-
-	if errCheck != nil {
-		if err := errCheck(data); err != nil {
-			return resp, err
-		}
+		return resp, nil, fmt.Errorf("failed to read response: %w", err)
 	}
+	return resp, data, nil
+}
 
-	if dest != nil {
-		if err := json.Unmarshal(data, dest); err != nil {
-			return resp, fmt.Errorf("failed to decode response: %w", err)
+// Delay before retrying: Retry-After if given, otherwise exponential backoff with full jitter
+func retryWait(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if v := resp.Header.Get("Retry-After"); v != "" {
+			if secs, err := strconv.Atoi(v); err == nil {
+				return min(time.Duration(secs)*time.Second, httpRetryMaxWait)
+			}
+			if t, err := http.ParseTime(v); err == nil {
+				return min(max(time.Until(t), 0), httpRetryMaxWait)
+			}
 		}
 	}
-
-	return resp, nil
+	backoff := min(httpRetryBase<<(attempt-1), httpRetryMaxBackoff)
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
 }
 
 // Build URL with query parameters
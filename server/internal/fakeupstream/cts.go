@@ -0,0 +1,148 @@
+package fakeupstream
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A stop point served by the fake CTS network
+type ctsStop struct {
+	ref   string
+	name  string
+	lines []ctsLine
+}
+
+type ctsLine struct {
+	ref          string
+	destinations []string
+	color        string
+	textColor    string
+}
+
+type ctsNetwork struct {
+	stops []ctsStop
+}
+
+func newCTSNetwork() *ctsNetwork {
+	lineA := ctsLine{ref: "A", destinations: []string{"Parc des Sports", "Illkirch Graffenstaden"}, color: "E10D19", textColor: "FFFFFF"}
+	lineB := ctsLine{ref: "B", destinations: []string{"Hoenheim Gare", "Lingolsheim Tiergaertel"}, color: "0099CC", textColor: "FFFFFF"}
+	lineC := ctsLine{ref: "C", destinations: []string{"Gare Centrale", "Neuhof Rodolphe Reuss"}, color: "F29400", textColor: "FFFFFF"}
+	lineD := ctsLine{ref: "D", destinations: []string{"Poteries", "Kehl Rathaus"}, color: "009933", textColor: "FFFFFF"}
+
+	return &ctsNetwork{stops: []ctsStop{
+		{ref: "GARE_C1", name: "Gare Centrale", lines: []ctsLine{only(lineC, 1)}},
+		{ref: "GARE_A1", name: "Gare Centrale", lines: []ctsLine{only(lineA, 0), only(lineD, 0)}},
+		{ref: "GARE_A2", name: "Gare Centrale", lines: []ctsLine{only(lineA, 1), only(lineD, 1)}},
+		{ref: "HDF_B1", name: "Homme de Fer", lines: []ctsLine{only(lineB, 1), only(lineC, 1)}},
+		{ref: "HDF_B2", name: "Homme de Fer", lines: []ctsLine{only(lineB, 0), only(lineC, 0)}},
+		{ref: "ALTW_B1", name: "Alt Winmärik", lines: []ctsLine{only(lineB, 1)}},
+		{ref: "ALTW_B2", name: "Alt Winmärik", lines: []ctsLine{only(lineB, 0)}},
+	}}
+}
+
+// Line restricted to one of its destinations, as served at a platform
+func only(line ctsLine, dest int) ctsLine {
+	line.destinations = []string{line.destinations[dest]}
+	return line
+}
+
+// CTS SIRI 2.0 endpoints
+func (s *Server) handleCTS(w http.ResponseWriter, r *http.Request) {
+	if s.down(w, "cts") {
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+		return
+	}
+	if s.scenarios.has("cts-error") {
+		writeJSON(w, http.StatusOK, map[string]string{"error": "Service temporarily unavailable"})
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/cts/v1/siri/2.0") {
+	case "/stoppoints-discovery":
+		s.handleStopPoints(w)
+	case "/stop-monitoring":
+		s.handleStopMonitoring(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Stop points with their lines and destinations
+func (s *Server) handleStopPoints(w http.ResponseWriter) {
+	var refs []map[string]any
+	for _, stop := range s.cts.stops {
+		var lines []map[string]any
+		for _, line := range stop.lines {
+			lines = append(lines, map[string]any{
+				"LineRef":      line.ref,
+				"Destinations": []map[string]any{{"DestinationName": line.destinations}},
+				"Extension":    map[string]string{"RouteColor": line.color, "RouteTextColor": line.textColor},
+			})
+		}
+		refs = append(refs, map[string]any{
+			"StopPointRef": stop.ref,
+			"StopName":     stop.name,
+			"Lines":        lines,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"StopPointsDelivery": map[string]any{"AnnotatedStopPointRef": refs},
+	})
+}
+
+// Next departures of a line at a stop point
+func (s *Server) handleStopMonitoring(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lineRef, stopRef := q.Get("LineRef"), q.Get("MonitoringRef")
+	count, _ := strconv.Atoi(q.Get("MinimumStopVisitsPerLine"))
+	if count <= 0 {
+		count = 2
+	}
+
+	visits := []map[string]any{}
+	if !s.scenarios.has("cts-no-departures") {
+		now := s.now()
+		for _, stop := range s.cts.stops {
+			if stop.ref != stopRef {
+				continue
+			}
+			for _, line := range stop.lines {
+				if lineRef != "" && line.ref != lineRef {
+					continue
+				}
+				for _, dest := range line.destinations {
+					// Headway of 5 to 9 minutes, stable within a quarter hour
+					headway := time.Duration(5+pick(stop.ref+line.ref+now.Format("15")+strconv.Itoa(now.Minute()/15), 5)) * time.Minute
+					first := time.Duration(1+now.Second()%4) * time.Minute
+					for i := 0; i < count; i++ {
+						visits = append(visits, map[string]any{
+							"MonitoredVehicleJourney": map[string]any{
+								"LineRef":         line.ref,
+								"DestinationName": dest,
+								"MonitoredCall": map[string]any{
+									"StopPointName":         stop.name,
+									"ExpectedDepartureTime": now.Add(first + time.Duration(i)*headway).Format(time.RFC3339),
+									"Extension":             map[string]bool{"IsRealTime": i < 2},
+								},
+							},
+						})
+					}
+				}
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ServiceDelivery": map[string]any{
+			"ResponseTimestamp": s.now().Format(time.RFC3339),
+			"StopMonitoringDelivery": []map[string]any{
+				{"MonitoredStopVisit": visits},
+			},
+		},
+	})
+}
@@ -0,0 +1,218 @@
+// Package fakeupstream emulates the upstream APIs used by the StrasBoard
+// server (Open-Meteo, CTS SIRI, RTE Tempo and SER), with scriptable
+// failure scenarios for end-to-end testing without network or credentials.
+package fakeupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Known scenarios, enabled with -scenario or at runtime through /_scenario
+var scenarioHelp = map[string]string{
+	"slow":              "delay every response by -delay",
+	"openmeteo-down":    "Open-Meteo returns 503",
+	"openmeteo-error":   "Open-Meteo returns a 400 error payload",
+	"cts-down":          "CTS returns 503",
+	"cts-error":         "CTS returns an error payload",
+	"cts-no-departures": "CTS returns no departures",
+	"rte-down":          "RTE returns 503",
+	"rte-auth-error":    "RTE rejects the client credentials",
+	"tempo-unpublished": "tomorrow's Tempo colour is not published yet",
+	"ser-down":          "SER returns 503",
+	"ser-login-failed":  "SER rejects the username or password",
+	"ser-error":         "SER returns an informative error message on consumption",
+	"ser-token-expiry":  "SER access tokens expire after -token-ttl, before the announced expiry",
+	"ser-missing-days":  "SER consumption misses yesterday and has a gap",
+	"ser-no-contract":   "SER returns no contract period on consumption",
+}
+
+// Scenarios toggled at runtime
+type scenarios struct {
+	mu     sync.RWMutex
+	active map[string]bool
+}
+
+func (s *scenarios) has(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active[name]
+}
+
+// Replace active scenarios from a comma-separated list, unless it
+// contains unknown names
+func (s *scenarios) set(list string) []string {
+	active := make(map[string]bool)
+	var unknown []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := scenarioHelp[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		active[name] = true
+	}
+	if len(unknown) > 0 {
+		return unknown
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = active
+	return nil
+}
+
+func (s *scenarios) list() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.active))
+	for name := range s.active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Server emulates the upstream APIs under /openmeteo, /cts, /rte and /ser,
+// with scenarios toggled at runtime through /_scenario
+type Server struct {
+	scenarios *scenarios
+	delay     time.Duration
+	tokenTTL  time.Duration
+	loc       *time.Location
+	offset    time.Duration
+	contract  string // base, hchp or tempo
+
+	cts *ctsNetwork
+	ser *serState
+	rte *rteState
+	mux *http.ServeMux
+}
+
+// Options of the fake upstream
+type Options struct {
+	// Response delay of the slow scenario
+	Delay time.Duration
+	// Server-side token lifetime of the ser-token-expiry scenario
+	TokenTTL time.Duration
+	// Start the clock at a given time, as the server's CLOCK_START
+	Start time.Time
+	// SER contract: base (BASE), hchp (HC/HP) or tempo (HC/HP per Tempo colour)
+	Contract string
+}
+
+// Create a fake upstream with no active scenario
+func New(opts Options) (*Server, error) {
+	if opts.Contract != "base" && opts.Contract != "hchp" && opts.Contract != "tempo" {
+		return nil, fmt.Errorf("invalid contract %q, expected base, hchp or tempo", opts.Contract)
+	}
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		return nil, fmt.Errorf("load location: %w", err)
+	}
+
+	s := &Server{
+		scenarios: &scenarios{},
+		delay:     opts.Delay,
+		tokenTTL:  opts.TokenTTL,
+		contract:  opts.Contract,
+		loc:       loc,
+		cts:       newCTSNetwork(),
+		ser:       newSERState(),
+		rte:       newRTEState(),
+		mux:       http.NewServeMux(),
+	}
+	if !opts.Start.IsZero() {
+		s.offset = time.Until(opts.Start)
+	}
+
+	s.mux.HandleFunc("/_scenario", s.handleScenario)
+	s.mux.HandleFunc("/openmeteo/v1/forecast", s.handleForecast)
+	s.mux.HandleFunc("/cts/v1/siri/2.0/", s.handleCTS)
+	s.mux.HandleFunc("/rte/", s.handleRTE)
+	s.mux.HandleFunc("/ser/", s.handleSER)
+	return s, nil
+}
+
+// Replace the active scenarios from a comma-separated list, returning
+// unknown names, in which case nothing changes
+func (s *Server) SetScenarios(list string) []string {
+	return s.scenarios.set(list)
+}
+
+// Active scenarios, sorted
+func (s *Server) Scenarios() []string {
+	return s.scenarios.list()
+}
+
+// Log requests and apply the slow scenario
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.RequestURI())
+	if s.scenarios.has("slow") && r.URL.Path != "/_scenario" {
+		select {
+		case <-time.After(s.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// List scenarios (GET) or replace active ones (POST ?set=a,b)
+func (s *Server) handleScenario(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		if unknown := s.scenarios.set(r.URL.Query().Get("set")); len(unknown) > 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "unknown scenarios", "unknown": unknown})
+			return
+		}
+		log.Printf("scenarios: %v", s.scenarios.list())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"active":    s.scenarios.list(),
+		"available": scenarioHelp,
+	})
+}
+
+// Answer 503 if the down scenario of a service is active
+func (s *Server) down(w http.ResponseWriter, service string) bool {
+	if !s.scenarios.has(service + "-down") {
+		return false
+	}
+	http.Error(w, service+" unavailable", http.StatusServiceUnavailable)
+	return true
+}
+
+// Current time in the upstream timezone
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset).In(s.loc)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Deterministic pseudo-random value in [0, n) for a key
+func pick(key string, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint32(key[i])) * 16777619
+	}
+	return int(h % uint32(n))
+}
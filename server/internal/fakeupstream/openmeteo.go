@@ -0,0 +1,123 @@
+package fakeupstream
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weatherCodes = []int{0, 1, 2, 3, 45, 51, 61, 63, 80, 95}
+
+// Open-Meteo forecast: minutely_15, hourly or daily variables
+func (s *Server) handleForecast(w http.ResponseWriter, r *http.Request) {
+	if s.down(w, "openmeteo") {
+		return
+	}
+	if s.scenarios.has("openmeteo-error") {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": true, "reason": "Cannot initialize WeatherVariable from invalid String value"})
+		return
+	}
+
+	q := r.URL.Query()
+	loc, err := time.LoadLocation(q.Get("timezone"))
+	if err != nil {
+		loc = time.UTC
+	}
+	resp := map[string]any{
+		"latitude":  q.Get("latitude"),
+		"longitude": q.Get("longitude"),
+		"timezone":  loc.String(),
+	}
+
+	switch {
+	case q.Has("minutely_15"):
+		count, _ := strconv.Atoi(q.Get("forecast_minutely_15"))
+		if count <= 0 {
+			count = 4
+		}
+		start := s.now().In(loc).Truncate(15 * time.Minute)
+		resp["minutely_15"] = weatherSeries(start, count, 15*time.Minute)
+
+	case q.Has("hourly"):
+		start, end, ok := dateRange(w, q.Get("start_date"), q.Get("end_date"), loc)
+		if !ok {
+			return
+		}
+		resp["hourly"] = weatherSeries(start, int(end.Sub(start)/time.Hour), time.Hour)
+
+	case q.Has("daily"):
+		start, end, ok := dateRange(w, q.Get("start_date"), q.Get("end_date"), loc)
+		if !ok {
+			return
+		}
+		var times []string
+		var codes []int
+		var maxTemps, minTemps []float64
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			key := d.Format(time.DateOnly)
+			times = append(times, key)
+			codes = append(codes, weatherCodes[pick(key, len(weatherCodes))])
+			maxTemps = append(maxTemps, seasonal(d)+6+float64(pick(key+"max", 40))/10)
+			minTemps = append(minTemps, seasonal(d)-4-float64(pick(key+"min", 40))/10)
+		}
+		resp["daily"] = map[string]any{
+			"time":               times,
+			"weather_code":       codes,
+			"temperature_2m_max": maxTemps,
+			"temperature_2m_min": minTemps,
+		}
+
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": true, "reason": "No variables requested"})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Time series of temperature, feels-like, day flag and weather code
+func weatherSeries(start time.Time, count int, step time.Duration) map[string]any {
+	times := make([]string, count)
+	temps := make([]float64, count)
+	feels := make([]float64, count)
+	isDay := make([]int, count)
+	codes := make([]int, count)
+	for i := range times {
+		t := start.Add(time.Duration(i) * step)
+		hour := float64(t.Hour()) + float64(t.Minute())/60
+		temp := seasonal(t) + 5*math.Sin((hour-9)*math.Pi/12)
+
+		times[i] = t.Format("2006-01-02T15:04")
+		temps[i] = math.Round(temp*10) / 10
+		feels[i] = math.Round((temp-1.5)*10) / 10
+		if t.Hour() >= 7 && t.Hour() < 19 {
+			isDay[i] = 1
+		}
+		codes[i] = weatherCodes[pick(t.Format("2006-01-02T15"), len(weatherCodes))]
+	}
+	return map[string]any{
+		"time":                 times,
+		"temperature_2m":       temps,
+		"apparent_temperature": feels,
+		"is_day":               isDay,
+		"weather_code":         codes,
+	}
+}
+
+// Mean temperature of the season
+func seasonal(t time.Time) float64 {
+	return 11 - 9*math.Cos(float64(t.YearDay()-20)*2*math.Pi/365)
+}
+
+// Parse an inclusive start_date/end_date range, returning the end as exclusive
+func dateRange(w http.ResponseWriter, startDate, endDate string, loc *time.Location) (time.Time, time.Time, bool) {
+	start, err1 := time.ParseInLocation(time.DateOnly, startDate, loc)
+	end, err2 := time.ParseInLocation(time.DateOnly, endDate, loc)
+	if err1 != nil || err2 != nil || end.Before(start) {
+		reason := "Invalid date range " + strings.Join([]string{startDate, endDate}, " - ")
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": true, "reason": reason})
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end.AddDate(0, 0, 1), true
+}
@@ -0,0 +1,146 @@
+package fakeupstream
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rteTokenTTL       = 2 * time.Hour
+	tempoPublishHour  = 11
+	rteCalendarsPath  = "/rte/open_api/tempo_like_supply_contract/v1/tempo_like_calendars"
+	rteTokenPath      = "/rte/token/oauth"
+	tempoBlue         = "BLUE"
+	tempoWhite        = "WHITE"
+	tempoRed          = "RED"
+	tempoRedSeasonEnd = time.April
+)
+
+type rteState struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func newRTEState() *rteState {
+	return &rteState{tokens: make(map[string]time.Time)}
+}
+
+// RTE OAuth and Tempo calendar endpoints
+func (s *Server) handleRTE(w http.ResponseWriter, r *http.Request) {
+	if s.down(w, "rte") {
+		return
+	}
+	switch r.URL.Path {
+	case rteTokenPath:
+		s.handleRTEToken(w, r)
+	case rteCalendarsPath:
+		s.handleTempoCalendars(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Client credentials grant with Basic authorization
+func (s *Server) handleRTEToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") || s.scenarios.has("rte-auth-error") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "Invalid client authentication",
+		})
+		return
+	}
+
+	token := randomToken()
+	s.rte.mu.Lock()
+	s.rte.tokens[token] = time.Now().Add(rteTokenTTL)
+	s.rte.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(rteTokenTTL.Seconds()),
+	})
+}
+
+// Tempo colours between start_date (inclusive) and end_date (exclusive)
+func (s *Server) handleTempoCalendars(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.rte.mu.Lock()
+	expiry, ok := s.rte.tokens[token]
+	s.rte.mu.Unlock()
+	if !ok || time.Now().After(expiry) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_token",
+			"error_description": "Access token is invalid or expired",
+		})
+		return
+	}
+
+	q := r.URL.Query()
+	start, err1 := time.Parse(time.RFC3339, q.Get("start_date"))
+	end, err2 := time.Parse(time.RFC3339, q.Get("end_date"))
+	if err1 != nil || err2 != nil || !end.After(start) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "TMPLIKSUPPCON_TEMPOLIKECALENDARS_F04",
+			"error_description": "The start_date and end_date fields are mandatory and must be valid dates",
+		})
+		return
+	}
+
+	// The next day's colour is published late in the morning
+	now := s.now()
+	published := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, s.loc)
+	if now.Hour() < tempoPublishHour || s.scenarios.has("tempo-unpublished") {
+		published = published.AddDate(0, 0, -1)
+	}
+
+	// Values are listed from the most recent day
+	values := []map[string]string{}
+	for d := end.In(s.loc).AddDate(0, 0, -1); !d.Before(start.In(s.loc)); d = d.AddDate(0, 0, -1) {
+		if d.After(published) {
+			continue
+		}
+		values = append(values, map[string]string{
+			"start_date":   d.Format(time.RFC3339),
+			"end_date":     d.AddDate(0, 0, 1).Format(time.RFC3339),
+			"value":        tempoColor(d),
+			"updated_date": d.AddDate(0, 0, -1).Add(10*time.Hour + 40*time.Minute).Format(time.RFC3339),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"tempo_like_calendars": map[string]any{
+			"start_date": start.Format(time.RFC3339),
+			"end_date":   end.Format(time.RFC3339),
+			"values":     values,
+		},
+	})
+}
+
+// Colour of a day: red on some winter weekdays, white on some other days
+func tempoColor(d time.Time) string {
+	key := d.Format(time.DateOnly)
+	winter := d.Month() >= time.November || d.Month() < tempoRedSeasonEnd
+	switch {
+	case winter && d.Weekday() != time.Saturday && d.Weekday() != time.Sunday && pick(key, 6) == 0:
+		return tempoRed
+	case d.Weekday() != time.Sunday && pick(key+"white", 7) == 0:
+		return tempoWhite
+	default:
+		return tempoBlue
+	}
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
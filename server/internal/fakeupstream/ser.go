@@ -0,0 +1,280 @@
+package fakeupstream
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serTokenTTL       = time.Hour
+	serServicePointID = "100042"
+	serReference      = "01234567890123"
+	serCookie         = "cookieOauth"
+)
+
+type serState struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	codes    map[string]string    // authorization code -> PKCE challenge
+	tokens   map[string]time.Time // access token -> server-side expiry
+}
+
+func newSERState() *serState {
+	return &serState{
+		sessions: make(map[string]bool),
+		codes:    make(map[string]string),
+		tokens:   make(map[string]time.Time),
+	}
+}
+
+// SER authentication and consumption endpoints
+func (s *Server) handleSER(w http.ResponseWriter, r *http.Request) {
+	if s.down(w, "ser") {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/ser")
+	switch {
+	case path == "/auth/externe/authentification":
+		s.handleSERLogin(w, r)
+	case path == "/auth/authorize-internet":
+		s.handleSERAuthorize(w, r)
+	case path == "/auth/tokenUtilisateurInternet":
+		s.handleSERToken(w, r)
+	case path == "/rest/produits/pointsAccesServicesClient":
+		s.handleSERServicePoints(w, r)
+	case strings.HasPrefix(path, "/rest/interfaces/") && strings.HasSuffix(path, "/historiqueDeMesure"):
+		s.handleSERConsumption(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Form login setting the session cookie
+func (s *Server) handleSERLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	if r.PostForm.Get("username") == "" || r.PostForm.Get("password") == "" || s.scenarios.has("ser-login-failed") {
+		writeJSON(w, http.StatusOK, map[string]string{"code": "1", "libelle": "Identifiant ou mot de passe incorrect"})
+		return
+	}
+
+	session := randomToken()
+	s.ser.mu.Lock()
+	s.ser.sessions[session] = true
+	s.ser.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: serCookie, Value: session, Path: "/", HttpOnly: true})
+	writeJSON(w, http.StatusOK, map[string]string{"code": "0", "libelle": "OK"})
+}
+
+// Authorization redirect carrying a code bound to the PKCE challenge
+func (s *Server) handleSERAuthorize(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(serCookie)
+	s.ser.mu.Lock()
+	valid := err == nil && s.ser.sessions[cookie.Value]
+	s.ser.mu.Unlock()
+	if !valid {
+		http.Redirect(w, r, "/ser/connexion", http.StatusFound)
+		return
+	}
+
+	challenge := r.URL.Query().Get("code_challenge")
+	if challenge == "" || r.URL.Query().Get("code_challenge_method") != "S256" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := randomToken()
+	s.ser.mu.Lock()
+	s.ser.codes[code] = challenge
+	s.ser.mu.Unlock()
+
+	http.Redirect(w, r, "/ser/espace-client/callback?"+url.Values{"code": {code}}.Encode(), http.StatusFound)
+}
+
+// Exchange an authorization code and PKCE verifier for an access token
+func (s *Server) handleSERToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	code, verifier := r.PostForm.Get("code"), r.PostForm.Get("code_verifier")
+
+	s.ser.mu.Lock()
+	challenge, ok := s.ser.codes[code]
+	delete(s.ser.codes, code)
+	s.ser.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(verifier))
+	if !ok || base64.RawURLEncoding.EncodeToString(hash[:]) != challenge {
+		writeJSON(w, http.StatusOK, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	// The expiry announced to clients stays the same when tokens are
+	// revoked early, as the real server does after a password change
+	expiry := time.Now().Add(serTokenTTL)
+	if s.scenarios.has("ser-token-expiry") {
+		expiry = time.Now().Add(s.tokenTTL)
+	}
+	token := randomToken()
+	s.ser.mu.Lock()
+	s.ser.tokens[token] = expiry
+	s.ser.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(serTokenTTL.Seconds()),
+	})
+}
+
+// Check the bearer token of a request
+func (s *Server) serAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.ser.mu.Lock()
+	expiry, ok := s.ser.tokens[token]
+	s.ser.mu.Unlock()
+	if !ok || time.Now().After(expiry) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return false
+	}
+	return true
+}
+
+// Service points of the customer
+func (s *Server) handleSERServicePoints(w http.ResponseWriter, r *http.Request) {
+	if !s.serAuthorized(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, []map[string]any{{
+		"id":             serServicePointID,
+		"pointDeService": map[string]string{"reference": serReference},
+	}})
+}
+
+// Daily and monthly consumption history per tariff period
+func (s *Server) handleSERConsumption(w http.ResponseWriter, r *http.Request) {
+	if !s.serAuthorized(w, r) {
+		return
+	}
+	if s.scenarios.has("ser-no-contract") {
+		writeJSON(w, http.StatusOK, map[string]any{"periodesActivite": []any{}})
+		return
+	}
+	if s.scenarios.has("ser-error") {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"messagesInformatifs": []string{"Les données de consommation sont momentanément indisponibles"},
+		})
+		return
+	}
+
+	var req struct {
+		DateDebut string `json:"dateDebut"`
+		DateFin   string `json:"dateFin"`
+		Point     struct {
+			ID string `json:"id"`
+		} `json:"pointAccesServicesClient"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"messagesInformatifs": []string{"Requête invalide"}})
+		return
+	}
+	start, err1 := time.Parse(time.RFC3339, req.DateDebut)
+	end, err2 := time.Parse(time.RFC3339, req.DateFin)
+	if err1 != nil || err2 != nil || req.Point.ID != serServicePointID {
+		writeJSON(w, http.StatusOK, map[string]any{"messagesInformatifs": []string{"Point d'accès inconnu ou période invalide"}})
+		return
+	}
+
+	// Data is available up to yesterday
+	now := s.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc)
+	if end.After(today) {
+		end = today
+	}
+	missing := s.scenarios.has("ser-missing-days")
+
+	tariffs := []string{"HC", "HP"}
+	switch s.contract {
+	case "base":
+		tariffs = []string{"BASE"}
+	case "tempo":
+		tariffs = []string{"BUHC", "BUHP", "BCHC", "BCHP", "RHC", "RHP"}
+	}
+
+	var postes []map[string]any
+	for _, tariff := range tariffs {
+		var daily []map[string]any
+		monthly := make(map[time.Time]float64)
+		for d := start.In(s.loc); d.Before(end); d = d.AddDate(0, 0, 1) {
+			day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, s.loc)
+			if missing && day.Equal(today.AddDate(0, 0, -1)) {
+				continue
+			}
+			var value *float64
+			if !missing || !day.Equal(today.AddDate(0, 0, -4)) {
+				v := consumption(day, tariff, s.contract == "tempo")
+				value = &v
+				month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, s.loc)
+				monthly[month] += v
+			}
+			daily = append(daily, map[string]any{"date": day.Format("02/01/2006"), "consommation": value})
+		}
+
+		var months []map[string]any
+		first := start.In(s.loc)
+		for month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, s.loc); month.Before(end); month = month.AddDate(0, 1, 0) {
+			if v, ok := monthly[month]; ok {
+				months = append(months, map[string]any{"annee": month.Year(), "mois": int(month.Month()), "consommation": v})
+			}
+		}
+
+		postes = append(postes, map[string]any{
+			"etiquette":                 map[string]string{"mnemo": tariff},
+			"consommationsJournalieres": daily,
+			"consommationsMensuelles":   months,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"periodesActivite": []map[string]any{{
+			"blocFournisseur": map[string]any{"postesHorosaisonnier": postes},
+		}},
+	})
+}
+
+// Consumption of a day in kWh, higher in winter and on weekends. On a
+// Tempo contract, tariffs of other colours than the day's get 0.
+func consumption(day time.Time, tariff string, tempo bool) float64 {
+	if tempo && tempoTariffPrefix[tempoColor(day)] != strings.TrimSuffix(strings.TrimSuffix(tariff, "HC"), "HP") {
+		return 0
+	}
+	base := 12 + 6*(11-seasonal(day))/9
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		base *= 1.2
+	}
+	share := 0.6
+	switch {
+	case tariff == "BASE":
+		share = 1
+	case strings.HasSuffix(tariff, "HC"):
+		share = 0.4
+	}
+	return math.Round(base*share*10)/10 + float64(pick(fmt.Sprint(day.Unix(), tariff), 8))/10
+}
+
+// Tariff code prefix of each Tempo colour
+var tempoTariffPrefix = map[string]string{
+	tempoBlue:  "BU",
+	tempoWhite: "BC",
+	tempoRed:   "R",
+}
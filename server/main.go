@@ -5,88 +5,231 @@ Aggregates data from multiple sources and serves a dashboard.
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const shutdownTimeout = 15 * time.Second
+
 //go:embed templates/*
 var templatesFS embed.FS
 
 //go:embed static/*
 var staticFS embed.FS
 
+// Combined responses keyed by source name
 type AllData struct {
-	Weather     *Response `json:"weather"`
-	Transport   *Response `json:"transport"`
-	Temperature *Response `json:"temperature"`
-	Electricity *Response `json:"electricity"`
-	Tempo       *Response `json:"tempo"`
-	Timestamp   string    `json:"timestamp"`
+	Sources   map[string]*Response
+	Timestamp string
+}
+
+// Flatten sources next to the timestamp
+func (d *AllData) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(d.Sources)+1)
+	for name, resp := range d.Sources {
+		m[name] = resp
+	}
+	m["timestamp"] = d.Timestamp
+	return json.Marshal(m)
 }
 
 // Main entry point
 func main() {
 	godotenv.Load()
 
-	cfg := LoadConfig()
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	UseFixtures(cfg.HTTP.Mode, cfg.HTTP.Fixtures)
+	if !cfg.ClockStart.IsZero() {
+		SetClock(NewFakeClock(cfg.ClockStart))
+		log.Printf("[clock] starting at %s", cfg.ClockStart.Format(time.RFC3339))
+	}
+
 	cache := NewCache()
+	if cfg.CacheFile != "" {
+		if err := cache.Persist(cfg.CacheFile); err != nil {
+			log.Printf("[cache] %v", err)
+		}
+	}
 
-	// Initialize sources
-	sources := map[string]Source{
-		"weather":     NewWeatherSource(cfg),
-		"transport":   NewTransportSource(cfg),
-		"temperature": NewTemperatureSource(cfg),
-		"electricity": NewElectricitySource(cfg),
-		"tempo":       NewTempoSource(cfg),
+	// Temperature history, fed by sensor pushes
+	var history *History
+	if cfg.HistoryDir != "" {
+		if history, err = NewHistory(cfg.HistoryDir); err != nil {
+			log.Printf("[history] %v", err)
+		}
+	}
+
+	// Initialize enabled sources
+	sources := NewSourceSet(BuildSources(cfg))
+
+	// Push source updates to stream clients
+	stream := NewStream()
+	cache.OnSet(stream.OnSet)
+
+	// Track per-source health
+	health := NewHealth(cache, sources, cfg.Health.Required)
+	cache.OnSet(health.OnSet)
+
+	// Evaluate alert rules on every update
+	alerts := NewAlerts(cache, cfg.Alerts, func() time.Time {
+		if temperature, ok := sources.Get("temperature").(*TemperatureSource); ok {
+			return temperature.LastPush()
+		}
+		return time.Time{}
+	})
+	cache.OnSet(alerts.OnSet)
+
+	// Mirror updates to an MQTT broker, starting with the cached data
+	var mqtt *MQTTPublisher
+	if cfg.MQTT.Broker != "" {
+		mqtt = NewMQTTPublisher(ctx, cfg.MQTT, cache)
+		for name := range sources.All() {
+			if resp := cache.Peek(name); resp != nil {
+				mqtt.OnSet(name, nil, resp)
+			}
+		}
+		cache.OnSet(mqtt.OnSet)
 	}
 
+	// Optional access control, public paths excepted
+	auth := NewAuth(cfg.Auth)
+
 	mux := http.NewServeMux()
 
 	// Static files
 	mux.Handle("/static/", http.FileServer(http.FS(staticFS)))
 
 	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		writeJSON(w, map[string]string{
-			"status":    "ok",
-			"timestamp": time.Now().UTC().Format(time.RFC3339),
-		})
-	})
+	mux.HandleFunc("/health", health.Handler())
+	mux.HandleFunc("/health/ready", health.ReadyHandler())
 
 	// Sensor push endpoint
-	mux.HandleFunc("/api/temperature/push", sources["temperature"].(*TemperatureSource).HandlePush())
+	metricTemperaturePushAge.SetFunc(func() float64 {
+		if temperature, ok := sources.Get("temperature").(*TemperatureSource); ok {
+			if last := temperature.LastPush(); !last.IsZero() {
+				return clock.Now().Sub(last).Seconds()
+			}
+		}
+		return -1
+	})
+	mux.HandleFunc("/api/temperature/push", func(w http.ResponseWriter, r *http.Request) {
+		temperature, ok := sources.Get("temperature").(*TemperatureSource)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		temperature.HandlePush(func(p TemperaturePayload) {
+			if history != nil {
+				history.Add(p)
+			}
+			if mqtt != nil {
+				mqtt.OnPush(p)
+			}
+			refresh(ctx, cache, temperature)
+		})(w, r)
+	})
+
+	// Temperature history
+	mux.HandleFunc("/api/temperature/history", func(w http.ResponseWriter, r *http.Request) {
+		if history == nil {
+			http.NotFound(w, r)
+			return
+		}
+		history.Handler()(w, r)
+	})
 
 	// Individual endpoints
-	for name, src := range sources {
-		mux.HandleFunc("/api/"+name, sourceHandler(src, cache))
-	}
+	api := sourceHandler(sources, cache)
+	mux.HandleFunc("/api/", api)
+
+	// Stored consumption by period with ?from=&to=&granularity=, the cached
+	// response otherwise
+	mux.HandleFunc("/api/electricity", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		electricity, ok := sources.Get("electricity").(*ElectricitySource)
+		if !ok || !(q.Has("from") || q.Has("to") || q.Has("granularity")) {
+			api(w, r)
+			return
+		}
+		electricity.HistoryHandler()(w, r)
+	})
+
+	// Accumulated consumption re-priced under alternative offers
+	mux.HandleFunc("/api/electricity/compare", compareHandler(sources))
 
-	// Transport live endpoint
+	// Tempo calendar for phone and desktop calendar apps
+	mux.HandleFunc("/api/tempo.ics", tempoCalendarHandler(sources, cache))
+
+	// Transport live endpoint, rate limited per client
+	metricTransportQuotaRemaining.SetFunc(func() float64 {
+		if transport, ok := sources.Get("transport").(*TransportSource); ok {
+			if quota := transport.quota(); quota != nil {
+				return float64(quota.Remaining)
+			}
+		}
+		return -1
+	})
 	mux.HandleFunc("/api/transport/live", func(w http.ResponseWriter, r *http.Request) {
+		transport, ok := sources.Get("transport").(*TransportSource)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if ok, wait := transport.AllowLive(clientIP(r)); !ok {
+			metricTransportLiveLimited.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			writeJSON(w, ErrorResponse("too many requests", wait))
+			return
+		}
 		idStr := r.URL.Query().Get("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			writeJSON(w, ErrorResponse("invalid id", time.Minute))
+			writeResponse(w, r, ErrorResponse("invalid id", time.Minute))
 			return
 		}
-		transport := sources["transport"].(*TransportSource)
-		writeJSON(w, transport.FetchLive(id))
+		writeResponse(w, r, transport.FetchLive(r.Context(), id))
 	})
 
+	// Prometheus metrics
+	mux.HandleFunc("/metrics", metricsHandler())
+
+	// Server-Sent Events stream of source updates
+	mux.HandleFunc("/api/stream", stream.Handler(cache, sources))
+
 	// All data combined
 	mux.HandleFunc("/api/all", func(w http.ResponseWriter, r *http.Request) {
-		data := fetchAll(cache, sources)
-		writeJSON(w, data)
+		data := fetchAll(r.Context(), cache, sources.All())
+		writeCachedJSON(w, r, data, combinedMeta(data.Sources))
 	})
 
+	// Login form for the dashboard
+	mux.HandleFunc("/login", auth.LoginHandler())
+	mux.HandleFunc("/logout", auth.LogoutHandler())
+
 	// HTML dashboard
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -105,40 +248,144 @@ func main() {
 		}
 	})
 
-	// Pre-warm cache
-	go fetchAll(cache, sources)
+	// Pre-warm cache, then keep it fresh in the background
+	scheduler := NewScheduler(ctx, cache)
+	go func() {
+		fetchAll(ctx, cache, sources.All())
+		health.SetReady()
+		scheduler.Sync(sources.All(), false)
+	}()
 
-	log.Printf("StrasBoard server starting on :%s", cfg.Port)
-	log.Fatal(http.ListenAndServe(":"+cfg.Port, mux))
+	// Reload configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			cfg = reloadConfig(cfg, sources, scheduler, health, auth, alerts)
+		}
+	}()
+
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: auth.Middleware(mux)}
+	server.RegisterOnShutdown(stream.Close)
+
+	go func() {
+		log.Printf("StrasBoard server starting on :%s", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("StrasBoard server shutting down")
+
+	// Stop background refreshes, then drain in-flight requests
+	scheduler.Stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if err := cache.Flush(); err != nil {
+		log.Printf("[cache] flush: %v", err)
+	}
+	if history != nil {
+		if err := history.Close(); err != nil {
+			log.Printf("[history] close: %v", err)
+		}
+	}
+	if mqtt != nil {
+		mqtt.Close()
+	}
 }
 
-// Create HTTP handler for a source
-func sourceHandler(src Source, cache *Cache) http.HandlerFunc {
+// Create HTTP handler serving /api/{source}
+func sourceHandler(sources *SourceSet, cache *Cache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		data := fetchCached(cache, src)
-		writeJSON(w, data)
+		src := sources.Get(strings.TrimPrefix(r.URL.Path, "/api/"))
+		if src == nil {
+			http.NotFound(w, r)
+			return
+		}
+		data := fetchCached(r.Context(), cache, src)
+		writeResponse(w, r, data)
+	}
+}
+
+// Reload configuration and rebuild the sources whose section changed.
+// An invalid configuration is rejected and the current one is kept.
+func reloadConfig(cfg *Config, sources *SourceSet, scheduler *Scheduler, health *Health, auth *Auth, alerts *Alerts) *Config {
+	next, err := LoadConfig()
+	if err != nil {
+		log.Printf("[config] reload rejected:\n%v", err)
+		return cfg
+	}
+	if next.Port != cfg.Port || next.CacheFile != cfg.CacheFile || next.HistoryDir != cfg.HistoryDir || next.MQTT != cfg.MQTT || next.HTTP != cfg.HTTP || !next.ClockStart.Equal(cfg.ClockStart) {
+		log.Printf("[config] port, cache_file, history_dir, mqtt, http and clock_start changes require a restart")
 	}
+
+	rebuilt, changed := RebuildSources(cfg, next, sources.All())
+	sources.Replace(rebuilt)
+	scheduler.Sync(rebuilt, true)
+	health.SetRequired(next.Health.Required)
+	auth.SetConfig(next.Auth)
+	alerts.SetConfig(next.Alerts)
+
+	log.Printf("[config] reloaded, sources changed: %v", changed)
+	return next
 }
 
 // Fetch source data with caching and degraded mode
-func fetchCached(cache *Cache, src Source) *Response {
+func fetchCached(ctx context.Context, cache *Cache, src Source) *Response {
 	if cached := cache.Get(src.Name()); cached != nil {
 		return cached
 	}
+	// Expired data is served while the scheduler refreshes it
+	if stale := cache.Peek(src.Name()); stale != nil {
+		return stale
+	}
+	return refresh(ctx, cache, src)
+}
+
+// Fetch source data from upstream and store it in the cache,
+// sharing a single fetch among concurrent callers
+func refresh(ctx context.Context, cache *Cache, src Source) *Response {
+	resp, err := cache.inflight.Do(ctx, src.Name(), func(ctx context.Context) (*Response, error) {
+		ctx, cancel := context.WithTimeout(ctx, src.Timeout())
+		defer cancel()
 
-	resp := src.Fetch()
-	if resp.Error != "" {
-		if backup := cache.GetBackup(src.Name()); backup != nil {
-			resp = DegradedResponse(backup, resp)
+		start := time.Now()
+		resp := src.Fetch(ctx)
+
+		// Abandoned by every caller or shutting down: keep the cache as is
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ctx.Err()
 		}
-	}
 
-	cache.Set(src.Name(), resp, src.DegradedTTL())
+		metricSourceFetches.Inc(src.Name())
+		metricSourceLatency.ObserveSince(start, src.Name())
+
+		degraded := false
+		if resp.Error != "" {
+			metricSourceErrors.Inc(src.Name())
+			if backup := cache.GetBackup(src.Name()); backup != nil {
+				resp = DegradedResponse(backup, resp)
+				degraded = true
+			}
+		}
+		setDegraded(src.Name(), degraded)
+
+		cache.Set(src.Name(), resp, src.DegradedTTL())
+		return resp, nil
+	})
+	if err != nil {
+		return ErrorResponse(err.Error(), 0)
+	}
 	return resp
 }
 
 // Fetch all sources concurrently
-func fetchAll(cache *Cache, sources map[string]Source) *AllData {
+func fetchAll(ctx context.Context, cache *Cache, sources map[string]Source) *AllData {
 	results := make(map[string]*Response)
 	var mu sync.Mutex
 	var wg sync.WaitGroup
@@ -147,7 +394,7 @@ func fetchAll(cache *Cache, sources map[string]Source) *AllData {
 		wg.Add(1)
 		go func(n string, s Source) {
 			defer wg.Done()
-			resp := fetchCached(cache, s)
+			resp := fetchCached(ctx, cache, s)
 			mu.Lock()
 			results[n] = resp
 			mu.Unlock()
@@ -156,12 +403,8 @@ func fetchAll(cache *Cache, sources map[string]Source) *AllData {
 	wg.Wait()
 
 	return &AllData{
-		Weather:     results["weather"],
-		Transport:   results["transport"],
-		Temperature: results["temperature"],
-		Electricity: results["electricity"],
-		Tempo:       results["tempo"],
-		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Sources:   results,
+		Timestamp: clock.Now().UTC().Format(time.RFC3339),
 	}
 }
 
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics exposed in Prometheus text format on /metrics
+var (
+	metricSourceFetches = newCounterVec("strasboard_source_fetches_total",
+		"Number of upstream fetches per source.", "source")
+	metricSourceErrors = newCounterVec("strasboard_source_fetch_errors_total",
+		"Number of failed upstream fetches per source.", "source")
+	metricSourceLatency = newHistogramVec("strasboard_source_fetch_duration_seconds",
+		"Duration of upstream fetches per source.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20}, "source")
+	metricSourceDegraded = newGaugeVec("strasboard_source_degraded",
+		"Whether the source currently serves backup data (1) or not (0).", "source")
+	metricSourceDegradedSince = newGaugeVec("strasboard_source_degraded_since_seconds",
+		"Unix time at which the source entered degraded mode, 0 if not degraded.", "source")
+
+	metricCacheHits = newCounterVec("strasboard_cache_hits_total",
+		"Number of cache lookups returning a valid response.", "key")
+	metricCacheMisses = newCounterVec("strasboard_cache_misses_total",
+		"Number of cache lookups returning nothing or an expired response.", "key")
+
+	metricUpstreamResponses = newCounterVec("strasboard_upstream_responses_total",
+		"Number of upstream HTTP responses per host and status code.", "host", "code")
+	metricUpstreamLatency = newHistogramVec("strasboard_upstream_request_duration_seconds",
+		"Duration of upstream HTTP requests per host.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "host")
+
+	metricElectricityLogins = newCounterVec("strasboard_electricity_logins_total",
+		"Number of full SER authentication flows.")
+	metricTemperaturePushAge = newGaugeVec("strasboard_temperature_last_push_age_seconds",
+		"Seconds since the last accepted sensor push, -1 if none yet.")
+	metricTemperaturePushRejected = newCounterVec("strasboard_temperature_push_rejected_total",
+		"Number of rejected sensor pushes per reason.", "reason")
+	metricTransportQuotaRemaining = newGaugeVec("strasboard_transport_quota_remaining",
+		"CTS calls left in the daily budget, -1 without budget.")
+	metricTransportLiveLimited = newCounterVec("strasboard_transport_live_rate_limited_total",
+		"Number of live transport refreshes rejected by the per-client rate limit.")
+	metricAlertNotifications = newCounterVec("strasboard_alert_notifications_total",
+		"Number of alert notifications per rule and status.", "rule", "status")
+	metricAlertSinkErrors = newCounterVec("strasboard_alert_sink_errors_total",
+		"Number of failed alert deliveries per sink type.", "sink")
+	metricAuthRejected = newCounterVec("strasboard_auth_rejected_total",
+		"Number of rejected dashboard and API authentications per method.", "method")
+)
+
+// Metric families in exposition order
+var metricFamilies = []metricFamily{
+	metricSourceFetches, metricSourceErrors, metricSourceLatency,
+	metricSourceDegraded, metricSourceDegradedSince,
+	metricCacheHits, metricCacheMisses,
+	metricUpstreamResponses, metricUpstreamLatency,
+	metricElectricityLogins, metricTemperaturePushAge, metricTemperaturePushRejected,
+	metricTransportQuotaRemaining, metricTransportLiveLimited,
+	metricAlertNotifications, metricAlertSinkErrors,
+	metricAuthRejected,
+}
+
+type metricFamily interface {
+	write(w io.Writer)
+}
+
+// Base for labelled metric families
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+}
+
+func (m *metricVec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+}
+
+// Format a label set, optionally with an extra label
+func (m *metricVec) labelString(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, m.labels[i]+"="+strconv.Quote(v))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Monotonic counter per label set
+type counterVec struct {
+	metricVec
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		metricVec: metricVec{name: name, help: help, kind: "counter", labels: labels},
+		values:    make(map[string]float64),
+	}
+}
+
+func (c *counterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelKey(values)]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(splitKey(k, len(c.labels))), formatFloat(c.values[k]))
+	}
+}
+
+// Gauge per label set, optionally computed at scrape time
+type gaugeVec struct {
+	metricVec
+	values map[string]float64
+	funcs  map[string]func() float64
+}
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{
+		metricVec: metricVec{name: name, help: help, kind: "gauge", labels: labels},
+		values:    make(map[string]float64),
+		funcs:     make(map[string]func() float64),
+	}
+}
+
+func (g *gaugeVec) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[labelKey(values)] = v
+}
+
+// Compute the gauge value on every scrape
+func (g *gaugeVec) SetFunc(fn func() float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.funcs[labelKey(values)] = fn
+}
+
+func (g *gaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	all := make(map[string]float64, len(g.values)+len(g.funcs))
+	for k, v := range g.values {
+		all[k] = v
+	}
+	for k, fn := range g.funcs {
+		all[k] = fn()
+	}
+	for _, k := range sortedKeys(all) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(splitKey(k, len(g.labels))), formatFloat(all[k]))
+	}
+}
+
+// Histogram with fixed buckets per label set
+type histogramVec struct {
+	metricVec
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		metricVec: metricVec{name: name, help: help, kind: "histogram", labels: labels},
+		buckets:   buckets,
+		values:    make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := labelKey(values)
+	hist := h.values[k]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hist
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// Observe the time elapsed since start
+func (h *histogramVec) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range sortedKeys(h.values) {
+		hist := h.values[k]
+		values := splitKey(k, len(h.labels))
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatFloat(b)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), hist.count)
+	}
+}
+
+// Update degraded-mode gauges, keeping the time degraded mode started
+func setDegraded(source string, degraded bool) {
+	g := metricSourceDegradedSince
+	g.mu.Lock()
+	since := g.values[labelKey([]string{source})]
+	switch {
+	case degraded && since == 0:
+		since = float64(clock.Now().Unix())
+	case !degraded:
+		since = 0
+	}
+	g.values[labelKey([]string{source})] = since
+	g.mu.Unlock()
+
+	v := 0.0
+	if degraded {
+		v = 1
+	}
+	metricSourceDegraded.Set(v, source)
+}
+
+func splitKey(k string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.SplitN(k, "\xff", n)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// HTTP handler exposing all metrics
+func metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range metricFamilies {
+			m.write(w)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	counter := newCounterVec("test_requests_total", "Requests.", "host", "code")
+	counter.Inc("a.example", "200")
+	counter.Inc("a.example", "200")
+	counter.Inc("b.example", `5"0"0`)
+
+	gauge := newGaugeVec("test_quota", "Quota.")
+	calls := 0
+	gauge.SetFunc(func() float64 { calls++; return float64(calls) })
+
+	hist := newHistogramVec("test_duration_seconds", "Durations.", []float64{0.5, 1}, "source")
+	hist.Observe(0.2, "tempo")
+	hist.Observe(0.7, "tempo")
+	hist.Observe(3, "tempo")
+
+	var b strings.Builder
+	for _, m := range []metricFamily{counter, gauge, gauge, hist} {
+		m.write(&b)
+	}
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{host="a.example",code="200"} 2
+test_requests_total{host="b.example",code="5\"0\"0"} 1
+# HELP test_quota Quota.
+# TYPE test_quota gauge
+test_quota 1
+# HELP test_quota Quota.
+# TYPE test_quota gauge
+test_quota 2
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{source="tempo",le="0.5"} 1
+test_duration_seconds_bucket{source="tempo",le="1"} 2
+test_duration_seconds_bucket{source="tempo",le="+Inf"} 3
+test_duration_seconds_sum{source="tempo"} 3.9
+test_duration_seconds_count{source="tempo"} 3
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+// Degraded mode keeps the time it started until the source recovers
+func TestSetDegraded(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	start := c.Now().Unix()
+
+	setDegraded("metrics-test", true)
+	c.Advance(time.Hour)
+	setDegraded("metrics-test", true)
+
+	w := httptest.NewRecorder()
+	metricsHandler()(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`strasboard_source_degraded{source="metrics-test"} 1`,
+		`strasboard_source_degraded_since_seconds{source="metrics-test"} ` + formatFloat(float64(start)),
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+
+	setDegraded("metrics-test", false)
+	w = httptest.NewRecorder()
+	metricsHandler()(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `strasboard_source_degraded_since_seconds{source="metrics-test"} 0`+"\n") {
+		t.Error("degraded since not reset on recovery")
+	}
+	if n := strings.Count(w.Body.String(), "# TYPE "); n != len(metricFamilies) {
+		t.Errorf("%d families exposed, want %d", n, len(metricFamilies))
+	}
+}
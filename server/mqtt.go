@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTTPublisher mirrors source data and sensor pushes to an MQTT broker as
+// retained messages, and announces them as Home Assistant entities through
+// MQTT discovery. Topics, under the topic prefix:
+//
+//	status                       online/offline, offline set by the broker when the server is gone
+//	<source>                     data of the latest response
+//	<source>/status              online/offline, offline while the source has no data
+//	sensor/<location>            latest push of a sensor
+//	tempo/today, tempo/tomorrow  colour, unknown until published
+//	electricity/daily            consumption per tariff of the latest day, in kWh
+type MQTTPublisher struct {
+	client    *mqttClient
+	cache     *Cache
+	prefix    string
+	discovery string
+	node      string
+	loc       *time.Location
+	midnights sync.WaitGroup
+}
+
+// Home Assistant sensor announced through discovery
+type haSensor struct {
+	id          string // object ID, after the node ID
+	name        string
+	topic       string
+	template    string
+	attributes  string // topic of JSON attributes
+	unit        string
+	deviceClass string
+	stateClass  string
+	icon        string
+	source      string // source whose status the sensor depends on
+}
+
+// Connect to the broker in the background until ctx is cancelled
+func NewMQTTPublisher(ctx context.Context, cfg MQTTConfig, cache *Cache) *MQTTPublisher {
+	loc, _ := time.LoadLocation("Europe/Paris")
+	if loc == nil {
+		loc = time.Local
+	}
+	m := &MQTTPublisher{
+		client:    newMQTTClient(cfg, cfg.TopicPrefix+"/status"),
+		cache:     cache,
+		prefix:    cfg.TopicPrefix,
+		discovery: cfg.DiscoveryPrefix,
+		node:      cfg.ClientID,
+		loc:       loc,
+	}
+	m.midnights.Add(1)
+	go m.client.run(ctx)
+	go m.midnight(ctx)
+	return m
+}
+
+// Wait until offline is published after ctx is cancelled
+func (m *MQTTPublisher) Close() {
+	m.client.wait()
+	m.midnights.Wait()
+}
+
+func (m *MQTTPublisher) topic(parts ...string) string {
+	return m.prefix + "/" + strings.Join(parts, "/")
+}
+
+// Cache listener publishing the data of every refresh
+func (m *MQTTPublisher) OnSet(key string, _, resp *Response) {
+	if resp.Data == nil {
+		m.client.Publish(m.topic(key, "status"), []byte("offline"))
+		return
+	}
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		log.Printf("[mqtt] %s: %v", key, err)
+		return
+	}
+	m.client.Publish(m.topic(key), data)
+	m.client.Publish(m.topic(key, "status"), []byte("online"))
+
+	switch key {
+	case "tempo":
+		m.publishTempo(resp)
+	case "electricity":
+		m.publishElectricity(resp)
+	case "temperature":
+		m.announce(haSensor{id: "temperature", name: "Indoor temperature", topic: m.topic(key), template: "{{ value_json.temperature }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement", source: key})
+		m.announce(haSensor{id: "humidity", name: "Indoor humidity", topic: m.topic(key), template: "{{ value_json.humidity }}", unit: "%", deviceClass: "humidity", stateClass: "measurement", source: key})
+	case "weather":
+		m.announce(haSensor{id: "outdoor_temperature", name: "Outdoor temperature", topic: m.topic(key), template: "{{ value_json.current.temperature }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement", source: key})
+	}
+}
+
+// Publish a sensor push
+func (m *MQTTPublisher) OnPush(p TemperaturePayload) {
+	location := haSlug(p.Location)
+	topic := m.topic("sensor", location)
+	payload, _ := json.Marshal(struct {
+		TemperaturePayload
+		Time string `json:"time"`
+	}{p, clock.Now().UTC().Format(time.RFC3339)})
+	m.client.Publish(topic, payload)
+
+	name := p.Location
+	if name == "" {
+		name = "Sensor"
+	}
+	m.announce(haSensor{id: "sensor_" + location + "_temperature", name: name + " temperature", topic: topic, template: "{{ value_json.temperature }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"})
+	m.announce(haSensor{id: "sensor_" + location + "_humidity", name: name + " humidity", topic: topic, template: "{{ value_json.humidity }}", unit: "%", deviceClass: "humidity", stateClass: "measurement"})
+}
+
+// Publish the colours of today and tomorrow, also stale ones since they
+// are dated
+func (m *MQTTPublisher) publishTempo(resp *Response) {
+	data, ok := decodeValue[TempoData](resp.Data)
+	if !ok {
+		return
+	}
+	now := clock.Now().In(m.loc)
+	for i, day := range []string{"today", "tomorrow"} {
+		date := now.AddDate(0, 0, i).Format(time.DateOnly)
+		color := "unknown"
+		for _, d := range data {
+			if d.Date == date {
+				color = d.Color
+			}
+		}
+		m.client.Publish(m.topic("tempo", day), []byte(color))
+		m.announce(haSensor{id: "tempo_" + day, name: "Tempo " + day, topic: m.topic("tempo", day), icon: "mdi:flash", source: "tempo"})
+	}
+}
+
+// Publish the consumption per tariff of the latest day
+func (m *MQTTPublisher) publishElectricity(resp *Response) {
+	data, ok := decodeValue[ElectricityData](resp.Data)
+	if !ok || len(data.Days) == 0 {
+		return
+	}
+	day := data.Days[len(data.Days)-1]
+	tariffs := day.Tariffs()
+
+	values := map[string]any{"date": day.Date}
+	total := 0
+	for tariff, v := range tariffs {
+		values[tariff] = v
+		total += v
+	}
+	values["total"] = total
+	payload, _ := json.Marshal(values)
+	topic := m.topic("electricity", "daily")
+	m.client.Publish(topic, payload)
+
+	sensor := haSensor{topic: topic, attributes: topic, unit: "kWh", deviceClass: "energy", icon: "mdi:flash", source: "electricity"}
+	sensor.id, sensor.name, sensor.template = "electricity_daily", "Electricity daily", "{{ value_json.total }}"
+	m.announce(sensor)
+	for _, tariff := range sortedKeys(tariffs) {
+		sensor.id = "electricity_daily_" + strings.ToLower(tariff)
+		sensor.name = "Electricity daily " + tariffNames[tariff]
+		sensor.template = "{{ value_json." + tariff + " | default(0) }}"
+		m.announce(sensor)
+	}
+}
+
+// Publish the discovery config of a sensor, e.g. sensor.strasboard_tempo_tomorrow
+func (m *MQTTPublisher) announce(s haSensor) {
+	availability := []map[string]string{{"topic": m.topic("status")}}
+	if s.source != "" {
+		availability = append(availability, map[string]string{"topic": m.topic(s.source, "status")})
+	}
+	config := map[string]any{
+		"name":              s.name,
+		"unique_id":         m.node + "_" + s.id,
+		"object_id":         m.node + "_" + s.id,
+		"state_topic":       s.topic,
+		"availability":      availability,
+		"availability_mode": "all",
+		"device": map[string]any{
+			"identifiers": []string{m.node},
+			"name":        "StrasBoard",
+			"model":       "StrasBoard server",
+		},
+	}
+	optional := map[string]string{
+		"value_template":        s.template,
+		"json_attributes_topic": s.attributes,
+		"unit_of_measurement":   s.unit,
+		"device_class":          s.deviceClass,
+		"state_class":           s.stateClass,
+		"icon":                  s.icon,
+	}
+	for k, v := range optional {
+		if v != "" {
+			config[k] = v
+		}
+	}
+	payload, _ := json.Marshal(config)
+	m.client.Publish(m.discovery+"/sensor/"+m.node+"/"+s.id+"/config", payload)
+}
+
+// Move today and tomorrow forward at midnight, the Tempo data being
+// refreshed later in the morning
+func (m *MQTTPublisher) midnight(ctx context.Context) {
+	defer m.midnights.Done()
+	for {
+		now := clock.Now().In(m.loc)
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 1, 0, m.loc)
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if resp := m.cache.Peek("tempo"); resp != nil {
+			m.publishTempo(resp)
+		}
+	}
+}
+
+// Lowercase identifier usable in topics and entity IDs
+func haSlug(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "_")
+	if slug == "" {
+		return "default"
+	}
+	return slug
+}
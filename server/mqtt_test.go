@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// In-process broker keeping the last retained payload of every topic and
+// the last will of its client
+type testBroker struct {
+	addr string
+
+	mu       sync.Mutex
+	retained map[string]string
+	will     string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	b := &testBroker{addr: l.Addr().String(), retained: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.ConnectPacket:
+			b.mu.Lock()
+			b.will = p.WillTopic + "=" + string(p.WillMessage)
+			b.mu.Unlock()
+			packets.NewControlPacket(packets.Connack).Write(conn)
+		case *packets.PublishPacket:
+			if p.Retain {
+				b.mu.Lock()
+				b.retained[p.TopicName] = string(p.Payload)
+				b.mu.Unlock()
+			}
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// Retained payload of a topic
+func (b *testBroker) get(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.retained[topic]
+	return v, ok
+}
+
+// Source data and sensor pushes are published as retained state, with
+// Home Assistant discovery configs, then marked offline on shutdown
+func TestMQTTPublisher(t *testing.T) {
+	fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	broker := newTestBroker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := MQTTConfig{Broker: "tcp://" + broker.addr, ClientID: "strasboard", TopicPrefix: "strasboard", DiscoveryPrefix: "homeassistant"}
+	m := NewMQTTPublisher(ctx, cfg, NewCache())
+
+	// Published now or on connection
+	m.OnSet("temperature", nil, NewResponse(TemperatureData{Temperature: 20.5, Humidity: 45, Location: "Living Room"}, time.Minute))
+	m.OnSet("tempo", nil, NewResponse(TempoData{{Date: "2026-01-15", Color: "red"}}, time.Hour))
+	m.OnSet("weather", nil, ErrorResponse("upstream down", time.Minute))
+	m.OnPush(TemperaturePayload{Temperature: 19, Humidity: 50, Location: "Chambre 2"})
+
+	state := map[string]string{
+		"strasboard/status":                                                "online",
+		"strasboard/temperature":                                           `{"temperature":20.5,"humidity":45,"location":"Living Room"}`,
+		"strasboard/temperature/status":                                    "online",
+		"strasboard/tempo/today":                                           "red",
+		"strasboard/tempo/tomorrow":                                        "unknown",
+		"strasboard/weather/status":                                        "offline",
+		"strasboard/sensor/chambre_2":                                      `{"temperature":19,"humidity":50,"location":"Chambre 2","time":"2026-01-15T11:00:00Z"}`,
+		"homeassistant/sensor/strasboard/temperature/config":               "",
+		"homeassistant/sensor/strasboard/tempo_today/config":               "",
+		"homeassistant/sensor/strasboard/sensor_chambre_2_humidity/config": "",
+	}
+	eventually(t, "the state to be published", func() bool {
+		for topic := range state {
+			if _, ok := broker.get(topic); !ok {
+				return false
+			}
+		}
+		return true
+	})
+	for topic, want := range state {
+		if got, _ := broker.get(topic); want != "" && got != want {
+			t.Errorf("%s: %s, want %s", topic, got, want)
+		}
+	}
+	broker.mu.Lock()
+	if broker.will != "strasboard/status=offline" {
+		t.Errorf("will %q, want offline on the status topic", broker.will)
+	}
+	broker.mu.Unlock()
+
+	tests := []struct {
+		id   string
+		want map[string]any
+	}{
+		{"temperature", map[string]any{
+			"name":                "Indoor temperature",
+			"unique_id":           "strasboard_temperature",
+			"state_topic":         "strasboard/temperature",
+			"value_template":      "{{ value_json.temperature }}",
+			"unit_of_measurement": "°C",
+			"device_class":        "temperature",
+			"availability":        []any{map[string]any{"topic": "strasboard/status"}, map[string]any{"topic": "strasboard/temperature/status"}},
+		}},
+		{"tempo_today", map[string]any{
+			"name":         "Tempo today",
+			"state_topic":  "strasboard/tempo/today",
+			"icon":         "mdi:flash",
+			"availability": []any{map[string]any{"topic": "strasboard/status"}, map[string]any{"topic": "strasboard/tempo/status"}},
+		}},
+		{"sensor_chambre_2_humidity", map[string]any{
+			"name":           "Chambre 2 humidity",
+			"state_topic":    "strasboard/sensor/chambre_2",
+			"value_template": "{{ value_json.humidity }}",
+			"availability":   []any{map[string]any{"topic": "strasboard/status"}},
+		}},
+	}
+	for _, tt := range tests {
+		payload, _ := broker.get("homeassistant/sensor/strasboard/" + tt.id + "/config")
+		var config map[string]any
+		if err := json.Unmarshal([]byte(payload), &config); err != nil {
+			t.Errorf("%s: %v", tt.id, err)
+			continue
+		}
+		for key, want := range tt.want {
+			if !reflect.DeepEqual(config[key], want) {
+				t.Errorf("%s: %s %v, want %v", tt.id, key, config[key], want)
+			}
+		}
+		if device, _ := config["device"].(map[string]any); device["name"] != "StrasBoard" {
+			t.Errorf("%s: device %v", tt.id, config["device"])
+		}
+	}
+
+	cancel()
+	m.Close()
+	eventually(t, "offline to be published on shutdown", func() bool {
+		status, _ := broker.get("strasboard/status")
+		return status == "offline"
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	mqttKeepAlive    = 60 * time.Second
+	mqttDialTimeout  = 10 * time.Second
+	mqttWriteTimeout = 10 * time.Second
+	mqttMaxBackoff   = 5 * time.Minute
+)
+
+// mqttClient publishes retained messages at QoS 0 through paho. It keeps
+// the last payload of every topic and publishes them again on each
+// connection, so that a restarted broker gets the full state. The
+// availability topic is set to online on connect and to offline by the
+// broker (last will) when the connection is lost.
+type mqttClient struct {
+	client       paho.Client
+	broker       string
+	availability string
+
+	mu       sync.Mutex
+	retained map[string][]byte
+	done     chan struct{}
+}
+
+func newMQTTClient(cfg MQTTConfig, availability string) *mqttClient {
+	c := &mqttClient{
+		broker:       mqttBrokerURL(cfg.Broker),
+		availability: availability,
+		retained:     make(map[string][]byte),
+		done:         make(chan struct{}),
+	}
+	opts := paho.NewClientOptions().
+		AddBroker(c.broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAlive).
+		SetConnectTimeout(mqttDialTimeout).
+		SetWriteTimeout(mqttWriteTimeout).
+		SetWill(availability, "offline", 0, true).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttMaxBackoff).
+		SetOnConnectHandler(c.connected).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("[mqtt] %s: %v", c.broker, err)
+		}).
+		SetReconnectingHandler(func(paho.Client, *paho.ClientOptions) {
+			log.Printf("[mqtt] reconnecting to %s", c.broker)
+		})
+	c.client = paho.NewClient(opts)
+	return c
+}
+
+// Broker URL with the default port of its scheme when missing
+func mqttBrokerURL(broker string) string {
+	u, err := url.Parse(broker)
+	if err != nil || u.Port() != "" {
+		return broker
+	}
+	port := "1883"
+	if u.Scheme == "ssl" || u.Scheme == "mqtts" {
+		port = "8883"
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), port)
+	return u.String()
+}
+
+// Connect to the broker until ctx is cancelled, retrying the first
+// connection with exponential backoff. Later reconnections are left to
+// paho.
+func (c *mqttClient) run(ctx context.Context) {
+	defer close(c.done)
+
+	backoff := time.Second
+	for {
+		token := c.client.Connect()
+		select {
+		case <-ctx.Done():
+			c.client.Disconnect(0)
+			return
+		case <-token.Done():
+		}
+		err := token.Error()
+		if err == nil {
+			break
+		}
+		log.Printf("[mqtt] %s: %v, reconnecting in %s", c.broker, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, mqttMaxBackoff)
+	}
+
+	<-ctx.Done()
+	if c.client.IsConnectionOpen() {
+		c.client.Publish(c.availability, 0, true, "offline").WaitTimeout(mqttWriteTimeout)
+	}
+	c.client.Disconnect(250)
+}
+
+// Wait for run to publish offline and disconnect
+func (c *mqttClient) wait() {
+	<-c.done
+}
+
+// Publish the availability and the retained state on each connection
+func (c *mqttClient) connected(client paho.Client) {
+	log.Printf("[mqtt] connected to %s", c.broker)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client.Publish(c.availability, 0, true, "online")
+	for _, topic := range sortedKeys(c.retained) {
+		client.Publish(topic, 0, true, c.retained[topic])
+	}
+}
+
+// Publish a retained message, now if connected or else on the next
+// connection. Unchanged payloads are not sent again.
+func (c *mqttClient) Publish(topic string, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prev, ok := c.retained[topic]; ok && string(prev) == string(payload) {
+		return
+	}
+	c.retained[topic] = payload
+	if c.client.IsConnectionOpen() {
+		token := c.client.Publish(topic, 0, true, payload)
+		go func() {
+			if token.WaitTimeout(mqttWriteTimeout) && token.Error() != nil {
+				log.Printf("[mqtt] publish %s: %v", topic, token.Error())
+			}
+		}()
+	}
+}
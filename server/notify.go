@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertSink delivers alert notifications
+type AlertSink interface {
+	Name() string
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Create a sink from its validated configuration
+func newAlertSink(cfg AlertSinkConfig) AlertSink {
+	switch cfg.Type {
+	case "ntfy":
+		return &ntfySink{url: cfg.URL, token: cfg.Token}
+	case "smtp":
+		port := cfg.Port
+		if port == 0 {
+			port = 587
+		}
+		return &smtpSink{
+			addr:     net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+			host:     cfg.Host,
+			username: cfg.Username,
+			password: cfg.Password,
+			from:     cfg.From,
+			to:       cfg.To,
+		}
+	}
+	return &webhookSink{url: cfg.URL}
+}
+
+// Client of webhook and ntfy sinks. Alerts are not upstream traffic: they
+// bypass circuit breakers, retries, upstream metrics and fixtures.
+var notifyClient = &http.Client{Timeout: alertSendTimeout}
+
+// POST a notification, failing on any non-2xx status
+func notifyPost(ctx context.Context, url string, body []byte, contentType string, headers http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, truncate(data, 100))
+	}
+	return nil
+}
+
+// One-line summary of an alert, used as title and subject
+func alertTitle(alert Alert) string {
+	if alert.Status == alertResolved {
+		return "[StrasBoard] Resolved: " + alert.Message
+	}
+	return "[StrasBoard] " + alert.Message
+}
+
+// webhookSink posts the alert as JSON
+type webhookSink struct {
+	url string
+}
+
+func (s *webhookSink) Name() string { return "webhook" }
+
+func (s *webhookSink) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return notifyPost(ctx, s.url, body, "application/json", nil)
+}
+
+// ntfySink publishes the message to an ntfy topic URL
+type ntfySink struct {
+	url   string
+	token string
+}
+
+func (s *ntfySink) Name() string { return "ntfy" }
+
+func (s *ntfySink) Send(ctx context.Context, alert Alert) error {
+	headers := http.Header{
+		"Title":    {mime.QEncoding.Encode("utf-8", "StrasBoard: "+alert.Rule)},
+		"Priority": {"high"},
+		"Tags":     {"warning"},
+	}
+	if alert.Status == alertResolved {
+		headers.Set("Priority", "default")
+		headers.Set("Tags", "white_check_mark")
+	}
+	if s.token != "" {
+		headers.Set("Authorization", "Bearer "+s.token)
+	}
+	body := alert.Message
+	if alert.Status == alertResolved {
+		body = "Resolved: " + body
+	}
+	return notifyPost(ctx, s.url, []byte(body), "text/plain; charset=utf-8", headers)
+}
+
+// smtpSink sends the alert by email, with STARTTLS when offered
+type smtpSink struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (s *smtpSink) Name() string { return "smtp" }
+
+func (s *smtpSink) Send(ctx context.Context, alert Alert) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", alertTitle(alert)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nRule: %s (%s)\r\nStatus: %s\r\nTime: %s\r\n", alert.Message, alert.Rule, alert.Type, alert.Status, alert.Time)
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	// net/smtp has no context support: give up waiting on cancellation
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, auth, s.from, s.to, []byte(msg.String()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
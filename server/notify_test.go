@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sinks deliver alerts even with upstream traffic replayed from fixtures
+func TestHTTPSinks(t *testing.T) {
+	transport := httpClient.Transport
+	UseFixtures("replay", t.TempDir())
+	t.Cleanup(func() {
+		httpClient.Transport = transport
+		httpNoRedirect.Transport = transport
+	})
+
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	alert := Alert{Rule: "cold", Type: "temperature_below", Status: alertFiring, Message: "Living Room at 15.2°C"}
+	ctx := context.Background()
+
+	if err := newAlertSink(AlertSinkConfig{Type: "webhook", URL: srv.URL + "/hook"}).Send(ctx, alert); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	var sent Alert
+	if err := json.Unmarshal([]byte(body), &sent); err != nil || sent != alert {
+		t.Errorf("webhook body %q, want the alert as JSON", body)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("webhook content type %q", ct)
+	}
+
+	alert.Status = alertResolved
+	if err := newAlertSink(AlertSinkConfig{Type: "ntfy", URL: srv.URL + "/topic", Token: "tk"}).Send(ctx, alert); err != nil {
+		t.Fatalf("ntfy: %v", err)
+	}
+	if body != "Resolved: Living Room at 15.2°C" {
+		t.Errorf("ntfy body %q", body)
+	}
+	for key, want := range map[string]string{"Authorization": "Bearer tk", "Priority": "default", "Tags": "white_check_mark"} {
+		if v := got.Header.Get(key); v != want {
+			t.Errorf("ntfy %s %q, want %q", key, v, want)
+		}
+	}
+
+	err := newAlertSink(AlertSinkConfig{Type: "webhook", URL: srv.URL + "/fail"}).Send(ctx, alert)
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("failing webhook: %v, want a 429 error", err)
+	}
+}
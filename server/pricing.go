@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Cost of a day or month of consumption in €, per tariff and subscription,
+// and per Tempo colour for Tempo tariffs
+type Cost struct {
+	Total        float64            `json:"total"`
+	Subscription float64            `json:"subscription"`
+	Tariffs      map[string]float64 `json:"tariffs"`
+	Colors       map[string]float64 `json:"colors,omitempty"`
+}
+
+// Cost of the month of the latest day so far, and projected to its end
+type MonthToDate struct {
+	Month      string  `json:"month"`
+	Days       int     `json:"days"`
+	Cost       float64 `json:"cost"`
+	Projection float64 `json:"projection"`
+}
+
+// Price periods sorted by date, as validated by checkPrices
+type priceTable []PriceConfig
+
+// Prices in effect on a day (YYYY-MM-DD), nil if none
+func (t priceTable) at(date string) *PriceConfig {
+	for i := len(t) - 1; i >= 0; i-- {
+		if p := &t[i]; p.From <= date {
+			if p.To != "" && date >= p.To {
+				return nil
+			}
+			return p
+		}
+	}
+	return nil
+}
+
+// Cost of consumption per tariff spread evenly over days, each day being
+// priced by the period it falls in so that a month can span a price change.
+// nil if a day or a tariff has no price.
+func (t priceTable) cost(tariffs map[string]float64, days []string) *Cost {
+	if len(days) == 0 {
+		return nil
+	}
+	share := 1 / float64(len(days))
+
+	cost := &Cost{Tariffs: make(map[string]float64, len(tariffs))}
+	for _, day := range days {
+		p := t.at(day)
+		if p == nil {
+			return nil
+		}
+		cost.Subscription += p.Subscription
+		for tariff, kwh := range tariffs {
+			if kwh == 0 {
+				continue
+			}
+			price, ok := p.KWh[tariff]
+			if !ok {
+				return nil
+			}
+			cost.Tariffs[tariff] += kwh * share * price
+		}
+	}
+
+	cost.Total = cost.Subscription
+	for tariff, v := range cost.Tariffs {
+		cost.Total += v
+		cost.Tariffs[tariff] = round2(v)
+		if color := tariffColor(tariff); color != "" {
+			if cost.Colors == nil {
+				cost.Colors = make(map[string]float64)
+			}
+			cost.Colors[color] += v
+		}
+	}
+	for color, v := range cost.Colors {
+		cost.Colors[color] = round2(v)
+	}
+	cost.Total = round2(cost.Total)
+	cost.Subscription = round2(cost.Subscription)
+	return cost
+}
+
+// Rounded consumption per tariff, as priced
+func kwhValues(tariffs map[string]int) map[string]float64 {
+	values := make(map[string]float64, len(tariffs))
+	for tariff, v := range tariffs {
+		values[tariff] = float64(v)
+	}
+	return values
+}
+
+// Compute the cost of every day and month, and of the current month so
+// far. The month of the latest day only counts the days up to it.
+func (t priceTable) apply(data *ElectricityData) {
+	if len(t) == 0 {
+		return
+	}
+	for i := range data.Days {
+		data.Days[i].Cost = t.cost(data.Days[i].KWh(), []string{data.Days[i].Date})
+	}
+
+	last := ""
+	if len(data.Days) > 0 {
+		last = data.Days[len(data.Days)-1].Date
+	}
+	for i := range data.Months {
+		m := &data.Months[i]
+		days, total := monthDays(m.Date, last)
+		m.Cost = t.cost(m.KWh(), days)
+		if m.Cost != nil && last != "" && strings.HasPrefix(last, m.Date) {
+			data.MonthToDate = &MonthToDate{
+				Month:      m.Date,
+				Days:       len(days),
+				Cost:       m.Cost.Total,
+				Projection: round2(m.Cost.Total / float64(len(days)) * float64(total)),
+			}
+		}
+	}
+}
+
+// Days of a month (YYYY-MM) up to the last day with data, and the number
+// of days in the month
+func monthDays(month, last string) ([]string, int) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return nil, 0
+	}
+	var days []string
+	total := 0
+	for d := start; d.Month() == start.Month(); d = d.AddDate(0, 0, 1) {
+		total++
+		if date := d.Format(time.DateOnly); last == "" || date <= last {
+			days = append(days, date)
+		}
+	}
+	return days, total
+}
+
+// Tariff code prefix of each Tempo colour
+var tempoTariffPrefixes = map[string]string{
+	"blue":  "BU",
+	"white": "BC",
+	"red":   "R",
+}
+
+// Tempo colour of a tariff code, empty for HC/HP
+func tariffColor(tariff string) string {
+	switch {
+	case strings.HasPrefix(tariff, "BU"):
+		return "blue"
+	case strings.HasPrefix(tariff, "BC"):
+		return "white"
+	case strings.HasPrefix(tariff, "R"):
+		return "red"
+	}
+	return ""
+}
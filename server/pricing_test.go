@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPriceCost(t *testing.T) {
+	prices := priceTable{
+		{From: "2025-01-01", Subscription: 0.5, KWh: map[string]float64{"BASE": 0.2, "HC": 0.2, "HP": 0.3, "BUHC": 0.1, "RHP": 0.7}},
+		{From: "2025-01-16", To: "2025-03-01", Subscription: 0.6, KWh: map[string]float64{"BASE": 0.25}},
+	}
+	january, _ := monthDays("2025-01", "")
+
+	tests := []struct {
+		name    string
+		tariffs map[string]float64
+		days    []string
+		want    *Cost
+	}{
+		{"base", map[string]float64{"BASE": 10.4}, []string{"2025-01-10"},
+			&Cost{Total: 2.58, Subscription: 0.5, Tariffs: map[string]float64{"BASE": 2.08}}},
+		{"hc/hp priced unrounded", map[string]float64{"HC": 3.4, "HP": 3.4}, []string{"2025-01-10"},
+			&Cost{Total: 2.2, Subscription: 0.5, Tariffs: map[string]float64{"HC": 0.68, "HP": 1.02}}},
+		{"tempo colours", map[string]float64{"BUHC": 10, "RHP": 2}, []string{"2025-01-10"},
+			&Cost{Total: 2.9, Subscription: 0.5, Tariffs: map[string]float64{"BUHC": 1, "RHP": 1.4}, Colors: map[string]float64{"blue": 1, "red": 1.4}}},
+		{"month across a price change", map[string]float64{"BASE": 31}, january,
+			&Cost{Total: 24.1, Subscription: 17.1, Tariffs: map[string]float64{"BASE": 7}}},
+		{"zero consumption of an unpriced tariff", map[string]float64{"BASE": 1, "HC": 0}, []string{"2025-02-01"},
+			&Cost{Total: 0.85, Subscription: 0.6, Tariffs: map[string]float64{"BASE": 0.25}}},
+		{"unpriced tariff", map[string]float64{"HC": 1}, []string{"2025-02-01"}, nil},
+		{"before the first period", map[string]float64{"BASE": 1}, []string{"2024-12-31"}, nil},
+		{"after the end date", map[string]float64{"BASE": 1}, []string{"2025-03-01"}, nil},
+		{"no days", map[string]float64{"BASE": 1}, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := prices.cost(tt.tariffs, tt.days); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Days and months are priced on their unrounded consumption, the month of
+// the latest day being projected to its end
+func TestPriceApply(t *testing.T) {
+	prices := priceTable{{From: "2025-01-01", Subscription: 1, KWh: map[string]float64{"BASE": 0.1}}}
+	days := make(map[string]map[string]float64)
+	for _, date := range []string{"2025-01-01", "2025-01-02", "2025-01-03", "2025-01-04", "2025-01-05"} {
+		days[date] = map[string]float64{"BASE": 1.4}
+	}
+	data := &ElectricityData{
+		Days:   aggregateConsumption(days, 5),
+		Months: aggregateConsumption(map[string]map[string]float64{"2025-01": {"BASE": 7}}, 1),
+	}
+	prices.apply(data)
+
+	day := data.Days[0]
+	if *day.BASE != 1 || day.Cost == nil || day.Cost.Total != 1.14 {
+		t.Errorf("day %d kWh costing %+v, want 1 kWh costing 1.14", *day.BASE, day.Cost)
+	}
+	if cost := data.Months[0].Cost; cost == nil || cost.Total != 5.7 || cost.Subscription != 5 {
+		t.Errorf("month costing %+v, want 5.7 with 5 days of subscription", cost)
+	}
+	want := &MonthToDate{Month: "2025-01", Days: 5, Cost: 5.7, Projection: 35.34}
+	if !reflect.DeepEqual(data.MonthToDate, want) {
+		t.Errorf("month to date %+v, want %+v", data.MonthToDate, want)
+	}
+}
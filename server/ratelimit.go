@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// failureLimiter blocks a client for the rest of the window once it has
+// made too many failed attempts, e.g. with invalid credentials.
+type failureLimiter struct {
+	max    int
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*failureWindow
+}
+
+type failureWindow struct {
+	count int
+	start time.Time
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:     max,
+		window:  window,
+		clients: make(map[string]*failureWindow),
+	}
+}
+
+// Time until the client may try again, 0 if not blocked
+func (l *failureLimiter) blocked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fw := l.clients[key]
+	if fw == nil || fw.count < l.max {
+		return 0
+	}
+	return max(fw.start.Add(l.window).Sub(clock.Now()), 0)
+}
+
+// Record a failed attempt
+func (l *failureLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := clock.Now()
+	for k, fw := range l.clients {
+		if now.Sub(fw.start) >= l.window {
+			delete(l.clients, k)
+		}
+	}
+	fw := l.clients[key]
+	if fw == nil {
+		fw = &failureWindow{start: now}
+		l.clients[key] = fw
+	}
+	fw.count++
+}
+
+// Forget failures after a successful attempt
+func (l *failureLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, key)
+}
+
+// rateLimiter allows each client a burst of requests, refilled at a
+// steady rate (token bucket).
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+// Take a token for the client, or return the time until one is available
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := clock.Now()
+	for k, b := range l.clients {
+		if b.refill(now, l) >= l.burst {
+			delete(l.clients, k)
+		}
+	}
+	b := l.clients[key]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[key] = b
+	}
+	if b.refill(now, l) < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Add tokens earned since the last refill, up to the burst size
+func (b *tokenBucket) refill(now time.Time, l *rateLimiter) float64 {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.rate, l.burst)
+	b.last = now
+	return b.tokens
+}
+
+// dailyBudget counts calls to an upstream API against a daily limit, reset
+// at midnight of the server clock. A zero limit disables the budget.
+type dailyBudget struct {
+	limit int
+
+	mu   sync.Mutex
+	day  time.Time
+	used int
+}
+
+func newDailyBudget(limit int) *dailyBudget {
+	return &dailyBudget{limit: limit}
+}
+
+// Daily budgets by source name, kept across config reloads so that
+// rebuilding the sources does not forget the calls made today
+var budgets = &budgetSet{sources: make(map[string]*dailyBudget)}
+
+type budgetSet struct {
+	mu      sync.Mutex
+	sources map[string]*dailyBudget
+}
+
+// Get or create the budget of a source. A changed limit applies to the
+// calls already counted today.
+func (b *budgetSet) get(source string, limit int) *dailyBudget {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	budget, ok := b.sources[source]
+	if ok && budget.limit == limit {
+		return budget
+	}
+	next := newDailyBudget(limit)
+	if ok {
+		budget.mu.Lock()
+		next.day, next.used = budget.day, budget.used
+		budget.mu.Unlock()
+	}
+	b.sources[source] = next
+	return next
+}
+
+// Start of the next day, resetting the count when a new day has begun.
+// Called with b.mu held.
+func (b *dailyBudget) rollover() time.Time {
+	now := clock.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !day.Equal(b.day) {
+		b.day, b.used = day, 0
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// Record a call, false if the budget is exhausted
+func (b *dailyBudget) spend() bool {
+	if b.limit == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rollover()
+	if b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// Calls left today and the time of the next reset
+func (b *dailyBudget) remaining() (int, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	resetsAt := b.rollover()
+	return max(b.limit-b.used, 0), resetsAt
+}
+
+// Stretch a TTL so that refreshes costing cost calls each fit in the
+// remaining budget until the reset. TTLs are unchanged while the budget
+// allows refreshing at the normal pace.
+func (b *dailyBudget) stretch(ttl time.Duration, cost int) time.Duration {
+	if b.limit == 0 {
+		return ttl
+	}
+	remaining, resetsAt := b.remaining()
+	left := resetsAt.Sub(clock.Now())
+	if remaining < cost {
+		return max(ttl, left)
+	}
+	return max(ttl, left/time.Duration(remaining/cost))
+}
+
+// Client address of a request, without port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	l := newRateLimiter(6, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d within the burst rejected", i)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok || wait <= 9*time.Second || wait > 10*time.Second {
+		t.Errorf("past the burst: %v, wait %s, want 10s", ok, wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Error("other client rejected")
+	}
+
+	// One token every 10 seconds, up to the burst
+	c.Advance(10 * time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Error("refilled token rejected")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Error("second request after a single refill allowed")
+	}
+	c.Advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d after an idle hour rejected", i)
+		}
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Error("bucket refilled beyond the burst")
+	}
+}
+
+func TestFailureLimiter(t *testing.T) {
+	c := fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	l := newFailureLimiter(3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if wait := l.blocked("a"); wait != 0 {
+			t.Fatalf("blocked after %d failures", i)
+		}
+		l.fail("a")
+		c.Advance(10 * time.Second)
+	}
+	if wait := l.blocked("a"); wait <= 29*time.Second || wait > 30*time.Second {
+		t.Errorf("blocked for %s, want the rest of the window", wait)
+	}
+	if wait := l.blocked("b"); wait != 0 {
+		t.Error("other client blocked")
+	}
+	c.Advance(30 * time.Second)
+	if wait := l.blocked("a"); wait != 0 {
+		t.Errorf("still blocked for %s after the window", wait)
+	}
+
+	l.fail("a")
+	l.fail("a")
+	l.reset("a")
+	l.fail("a")
+	if wait := l.blocked("a"); wait != 0 {
+		t.Error("failures counted across a reset")
+	}
+}
+
+// The budget resets at midnight, and TTLs stretch as it runs low
+func TestDailyBudget(t *testing.T) {
+	loc := paris(t)
+	c := fakeClock(t, time.Date(2026, 1, 15, 18, 0, 0, 0, loc))
+	midnight := time.Date(2026, 1, 16, 0, 0, 0, 0, loc)
+	b := newDailyBudget(720)
+
+	// Plenty left: a call every 30 seconds until midnight
+	if ttl := b.stretch(time.Minute, 1); ttl != time.Minute {
+		t.Errorf("ttl %s with the budget intact, want 1m", ttl)
+	}
+	for i := 0; i < 717; i++ {
+		b.spend()
+	}
+	if ttl := b.stretch(time.Minute, 1); ttl <= 2*time.Hour-time.Second || ttl > 2*time.Hour {
+		t.Errorf("ttl %s with 3 calls left for 6 hours, want 2h", ttl)
+	}
+	if ttl := b.stretch(time.Minute, 4); ttl <= 6*time.Hour-time.Second || ttl > 6*time.Hour {
+		t.Errorf("ttl %s with fewer calls left than a refresh costs, want until midnight", ttl)
+	}
+
+	for i := 0; i < 3; i++ {
+		b.spend()
+	}
+	if b.spend() {
+		t.Error("call past the limit allowed")
+	}
+	if remaining, resetsAt := b.remaining(); remaining != 0 || !resetsAt.Equal(midnight) {
+		t.Errorf("%d left until %s, want 0 until midnight", remaining, resetsAt)
+	}
+
+	c.Set(midnight.Add(time.Second))
+	if remaining, _ := b.remaining(); remaining != 720 || !b.spend() {
+		t.Errorf("%d left after midnight, want 720", remaining)
+	}
+
+	if unlimited := newDailyBudget(0); !unlimited.spend() || unlimited.stretch(time.Minute, 100) != time.Minute {
+		t.Error("zero limit restricts calls")
+	}
+}
+
+// Rebuilding the transport source on reload keeps the calls counted today
+func TestBudgetReload(t *testing.T) {
+	fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	cfg := defaultConfig()
+	cfg.Transport.APIKey = "key"
+	cfg.Transport.Stops = []StopConfig{{Line: "A", Stop: "Homme de Fer", Destination: "Illkirch"}}
+	cfg.Transport.DailyBudget = 10
+	t.Cleanup(func() { delete(budgets.sources, "transport") })
+
+	NewTransportSource(cfg).budget.spend()
+	NewTransportSource(cfg).budget.spend()
+	if remaining, _ := NewTransportSource(cfg).budget.remaining(); remaining != 8 {
+		t.Errorf("%d calls left after a reload, want 8", remaining)
+	}
+
+	cfg.Transport.DailyBudget = 5
+	if remaining, _ := NewTransportSource(cfg).budget.remaining(); remaining != 3 {
+		t.Errorf("%d calls left after lowering the budget, want 3", remaining)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sync"
+)
+
+// SourceFactory creates a source from configuration, or returns nil
+// when the source is not configured.
+type SourceFactory func(cfg *Config) Source
+
+var sourceFactories = make(map[string]SourceFactory)
+
+// Register a source factory under its name
+func RegisterSource(name string, factory SourceFactory) {
+	if _, ok := sourceFactories[name]; ok {
+		log.Panicf("source %q registered twice", name)
+	}
+	sourceFactories[name] = factory
+}
+
+// Create all sources that are enabled and configured
+func BuildSources(cfg *Config) map[string]Source {
+	sources := make(map[string]Source)
+	for _, name := range sortedKeys(sourceFactories) {
+		if src := buildSource(cfg, name); src != nil {
+			sources[name] = src
+		}
+	}
+	return sources
+}
+
+// Rebuild sources after a config change. Sources whose config section is
+// unchanged keep running as is; the names of rebuilt, added or removed
+// sources are returned.
+func RebuildSources(old, cfg *Config, current map[string]Source) (map[string]Source, []string) {
+	next := make(map[string]Source)
+	var changed []string
+
+	for _, name := range sortedKeys(sourceFactories) {
+		src, running := current[name]
+		if running && sourceEnabled(cfg, name) && reflect.DeepEqual(old.Section(name), cfg.Section(name)) {
+			next[name] = src
+			continue
+		}
+		if src := buildSource(cfg, name); src != nil {
+			next[name] = src
+			changed = append(changed, name)
+		} else if running {
+			changed = append(changed, name)
+		}
+	}
+	return next, changed
+}
+
+// Create a single source if enabled and configured
+func buildSource(cfg *Config, name string) Source {
+	if !sourceEnabled(cfg, name) {
+		log.Printf("[registry] %s disabled", name)
+		return nil
+	}
+	src := sourceFactories[name](cfg)
+	if src == nil {
+		log.Printf("[registry] %s not configured", name)
+		return nil
+	}
+	return src
+}
+
+// Check whether a source is enabled, all sources being enabled by default
+func sourceEnabled(cfg *Config, name string) bool {
+	if len(cfg.Sources) == 0 {
+		return true
+	}
+	for _, n := range cfg.Sources {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceSet holds the running sources, replaced on config reload
+type SourceSet struct {
+	mu      sync.RWMutex
+	sources map[string]Source
+}
+
+func NewSourceSet(sources map[string]Source) *SourceSet {
+	return &SourceSet{sources: sources}
+}
+
+// Get a running source by name
+func (s *SourceSet) Get(name string) Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sources[name]
+}
+
+// Get all running sources
+func (s *SourceSet) All() map[string]Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sources
+}
+
+// Replace the running sources
+func (s *SourceSet) Replace(sources map[string]Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = sources
+}
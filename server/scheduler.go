@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	schedulerLead        = 5 * time.Second
+	schedulerJitter      = 5 * time.Second
+	schedulerMinInterval = 10 * time.Second
+)
+
+// Scheduler refreshes each source in the background shortly before its
+// cached response expires, so HTTP handlers never wait on upstream APIs.
+type Scheduler struct {
+	cache *Cache
+	ctx   context.Context
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	loops  map[string]*schedulerLoop
+	wg     sync.WaitGroup
+}
+
+type schedulerLoop struct {
+	src    Source
+	cancel context.CancelFunc
+}
+
+func NewScheduler(ctx context.Context, cache *Cache) *Scheduler {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Scheduler{
+		cache:  cache,
+		ctx:    ctx,
+		cancel: cancel,
+		loops:  make(map[string]*schedulerLoop),
+	}
+}
+
+// Run one refresh loop per source, stopping loops of removed or replaced
+// sources. With immediate, new loops refresh their source right away.
+func (s *Scheduler) Sync(sources map[string]Source, immediate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+	for name, loop := range s.loops {
+		if sources[name] != loop.src {
+			loop.cancel()
+			delete(s.loops, name)
+		}
+	}
+	for name, src := range sources {
+		if _, ok := s.loops[name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		s.loops[name] = &schedulerLoop{src: src, cancel: cancel}
+		s.wg.Add(1)
+		go s.run(ctx, src, immediate)
+	}
+}
+
+// Stop all refresh loops, cancelling in-flight fetches, and wait for them to exit
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Refresh a source whenever its cached response is about to expire
+func (s *Scheduler) run(ctx context.Context, src Source, immediate bool) {
+	defer s.wg.Done()
+
+	for {
+		var next time.Duration
+		if resp := s.cache.Peek(src.Name()); resp != nil && !immediate {
+			next = nextRefresh(resp.ExpiresAt)
+		}
+		immediate = false
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		resp := refresh(ctx, s.cache, src)
+		if resp.Error != "" {
+			log.Printf("[scheduler] %s: %s (next in %s)", src.Name(), resp.Error, nextRefresh(resp.ExpiresAt).Round(time.Second))
+		}
+	}
+}
+
+// Compute delay before the next refresh, with jitter and a minimum interval
+func nextRefresh(expiresAt time.Time) time.Duration {
+	delay := expiresAt.Sub(clock.Now()) - schedulerLead - time.Duration(rand.Int63n(int64(schedulerJitter)))
+	if delay < schedulerMinInterval {
+		return schedulerMinInterval
+	}
+	return delay
+}
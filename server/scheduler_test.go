@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Source returning scripted responses, counting its fetches
+type testSource struct {
+	name    string
+	fetch   func(ctx context.Context) *Response
+	fetches atomic.Int32
+}
+
+func (s *testSource) Name() string               { return s.name }
+func (s *testSource) DegradedTTL() time.Duration { return time.Hour }
+func (s *testSource) Timeout() time.Duration     { return 5 * time.Second }
+
+func (s *testSource) Fetch(ctx context.Context) *Response {
+	s.fetches.Add(1)
+	return s.fetch(ctx)
+}
+
+// Wait until a condition holds, failing the test after a few seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+	now := clock.Now()
+
+	tests := []struct {
+		name     string
+		expires  time.Time
+		min, max time.Duration
+	}{
+		{"ahead of expiry", now.Add(time.Hour), time.Hour - schedulerLead - schedulerJitter, time.Hour - schedulerLead},
+		{"expiring soon", now.Add(3 * time.Second), schedulerMinInterval, schedulerMinInterval},
+		{"expired", now.Add(-time.Minute), schedulerMinInterval, schedulerMinInterval},
+	}
+	for _, tt := range tests {
+		if got := nextRefresh(tt.expires); got < tt.min-time.Second || got > tt.max {
+			t.Errorf("%s: %s, want between %s and %s", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+// New loops refresh right away with immediate, replaced sources get their
+// own loop, and Stop cancels in-flight fetches without caching their result
+func TestSchedulerSync(t *testing.T) {
+	cache := NewCache()
+	s := NewScheduler(context.Background(), cache)
+
+	first := &testSource{name: "a", fetch: func(context.Context) *Response {
+		return NewResponse("first", time.Hour)
+	}}
+	s.Sync(map[string]Source{"a": first}, true)
+	eventually(t, "the first fetch", func() bool { return cache.Peek("a") != nil })
+
+	// Fresh data: no refresh before it is about to expire
+	s.Sync(map[string]Source{"a": first}, false)
+	time.Sleep(50 * time.Millisecond)
+	if n := first.fetches.Load(); n != 1 {
+		t.Errorf("%d fetches of fresh data, want 1", n)
+	}
+
+	var cancelled atomic.Bool
+	blocked := make(chan struct{})
+	second := &testSource{name: "a", fetch: func(ctx context.Context) *Response {
+		close(blocked)
+		<-ctx.Done()
+		cancelled.Store(true)
+		return ErrorResponse(ctx.Err().Error(), time.Minute)
+	}}
+	s.Sync(map[string]Source{"a": second}, true)
+	<-blocked
+
+	// The fetch may return after Stop, which only waits for the loops
+	s.Stop()
+	eventually(t, "the in-flight fetch to be cancelled", cancelled.Load)
+	if data := cache.Peek("a").Data; data != "first" {
+		t.Errorf("cached %v after a cancelled fetch, want the first response", data)
+	}
+
+	// Stopped: Sync starts nothing
+	s.Sync(map[string]Source{"b": first}, true)
+	time.Sleep(50 * time.Millisecond)
+	if cache.Peek("b") != nil {
+		t.Error("refresh after Stop")
+	}
+}
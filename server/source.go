@@ -1,62 +1,94 @@
 package main
 
-import "time"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 type Source interface {
 	Name() string
-	Fetch() *Response
+	Fetch(ctx context.Context) *Response
 	DegradedTTL() time.Duration
+	// Deadline for a single Fetch
+	Timeout() time.Duration
 }
 
 type Response struct {
-	Data      any       `json:"data,omitempty"`
-	Timestamp string    `json:"timestamp"`
-	Error     string    `json:"error,omitempty"`
-	ExpiresAt time.Time `json:"-"`
+	Data      any    `json:"data,omitempty"`
+	Timestamp string `json:"timestamp"`
+	Error     string `json:"error,omitempty"`
+	// Data comes from backup after a failed fetch
+	Stale       bool      `json:"stale"`
+	LastAttempt string    `json:"last_attempt,omitempty"`
+	LastErrorAt string    `json:"last_error_at,omitempty"`
+	ExpiresAt   time.Time `json:"-"`
+}
+
+// Encode the response with its age and expiry at the time it is served
+func (r Response) MarshalJSON() ([]byte, error) {
+	type response Response
+	out := struct {
+		*response
+		AgeSeconds int    `json:"age_seconds"`
+		ExpiresAt  string `json:"expires_at"`
+	}{
+		response:  (*response)(&r),
+		ExpiresAt: r.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if t, err := time.Parse(time.RFC3339, r.Timestamp); err == nil {
+		out.AgeSeconds = max(int(clock.Now().Sub(t).Seconds()), 0)
+	}
+	return json.Marshal(out)
 }
 
 // Create a successful response with TTL
 func NewResponse(data any, ttl time.Duration) *Response {
-	return &Response{
-		Data:      data,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		ExpiresAt: time.Now().Add(ttl),
-	}
+	return NewResponseUntil(data, clock.Now().Add(ttl))
 }
 
 // Create a successful response with explicit expiration time
 func NewResponseUntil(data any, expiresAt time.Time) *Response {
+	now := clock.Now().UTC().Format(time.RFC3339)
 	return &Response{
-		Data:      data,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		ExpiresAt: expiresAt,
+		Data:        data,
+		Timestamp:   now,
+		LastAttempt: now,
+		ExpiresAt:   expiresAt,
 	}
 }
 
 // Create an error response
 func ErrorResponse(msg string, ttl time.Duration) *Response {
+	now := clock.Now().UTC().Format(time.RFC3339)
 	return &Response{
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Error:     msg,
-		ExpiresAt: time.Now().Add(ttl),
+		Timestamp:   now,
+		Error:       msg,
+		LastAttempt: now,
+		LastErrorAt: now,
+		ExpiresAt:   clock.Now().Add(ttl),
 	}
 }
 
 // Create a backup response from a successful response with degraded TTL
 func BackupResponse(original *Response, degradedTTL time.Duration) *Response {
 	return &Response{
-		Data:      original.Data,
-		Timestamp: original.Timestamp,
-		ExpiresAt: time.Now().Add(degradedTTL),
+		Data:        original.Data,
+		Timestamp:   original.Timestamp,
+		LastAttempt: original.LastAttempt,
+		ExpiresAt:   clock.Now().Add(degradedTTL),
 	}
 }
 
 // Create a degraded response combining backup data with error info
 func DegradedResponse(backup *Response, err *Response) *Response {
 	return &Response{
-		Data:      backup.Data,
-		Timestamp: backup.Timestamp,
-		Error:     err.Error,
-		ExpiresAt: err.ExpiresAt,
+		Data:        backup.Data,
+		Timestamp:   backup.Timestamp,
+		Error:       err.Error,
+		Stale:       true,
+		LastAttempt: err.LastAttempt,
+		LastErrorAt: err.LastErrorAt,
+		ExpiresAt:   err.ExpiresAt,
 	}
 }
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -18,14 +21,33 @@ import (
 const (
 	electricityRetryTTL    = 1 * time.Hour
 	electricityRefreshHour = 1
+	// Months of consumption per historiqueDeMesure request
+	electricityPageMonths = 2
+	// Pages fetched per refresh while backfilling, refreshing every minute
+	// until done
+	electricityBackfillPages = 6
+	electricityBackfillTTL   = time.Minute
+	// Consecutive pages without consumption ending the backfill, a gap
+	// such as a vacant home being skipped
+	electricityBackfillEmptyPages = 6
 )
 
+var errNoContractData = errors.New("no contract data")
+
 type ElectricitySource struct {
 	apiURL   string
 	clientID string
 	username string
 	password string
+	prices   priceTable
+	offers   []OfferConfig
+	backfill int // years
+	empty    int // consecutive empty backfill pages
+	days     int
+	months   int
+	store    *consumptionStore
 	loc      *time.Location
+	timeout  time.Duration
 
 	mu             sync.Mutex
 	accessToken    string
@@ -33,14 +55,30 @@ type ElectricitySource struct {
 	servicePointID string
 }
 
+// Tariff codes of prices and consumption values: BASE, or HC/HP prefixed
+// by the Tempo colour
+var tariffNames = map[string]string{
+	"BASE": "base",
+	"HC":   "off-peak",
+	"HP":   "peak",
+	"BUHC": "blue off-peak",
+	"BUHP": "blue peak",
+	"BCHC": "white off-peak",
+	"BCHP": "white peak",
+	"RHC":  "red off-peak",
+	"RHP":  "red peak",
+}
+
 // API response
 type ElectricityData struct {
-	Days   []Consumption `json:"days"`
-	Months []Consumption `json:"months"`
+	Days        []Consumption `json:"days"`
+	Months      []Consumption `json:"months"`
+	MonthToDate *MonthToDate  `json:"month_to_date,omitempty"`
 }
 
 type Consumption struct {
 	Date string `json:"date"`
+	BASE *int   `json:"BASE,omitempty"`
 	HC   *int   `json:"HC,omitempty"`
 	HP   *int   `json:"HP,omitempty"`
 	BCHC *int   `json:"BCHC,omitempty"`
@@ -49,6 +87,19 @@ type Consumption struct {
 	BUHP *int   `json:"BUHP,omitempty"`
 	RHC  *int   `json:"RHC,omitempty"`
 	RHP  *int   `json:"RHP,omitempty"`
+	// With configured prices
+	Cost *Cost `json:"cost,omitempty"`
+
+	kwh map[string]float64 // unrounded values, for pricing
+}
+
+func init() {
+	RegisterSource("electricity", func(cfg *Config) Source {
+		if cfg.Electricity.Username == "" || cfg.Electricity.Password == "" {
+			return nil
+		}
+		return NewElectricitySource(cfg)
+	})
 }
 
 func NewElectricitySource(cfg *Config) *ElectricitySource {
@@ -56,30 +107,54 @@ func NewElectricitySource(cfg *Config) *ElectricitySource {
 	if loc == nil {
 		loc = time.Local
 	}
+	// Stored in its own directory, with the temperature history, or kept
+	// in memory
+	dir := cfg.Electricity.HistoryDir
+	if dir == "" && cfg.HistoryDir != "" {
+		dir = filepath.Join(cfg.HistoryDir, "electricity")
+	}
+	store, err := newConsumptionStore(dir)
+	if err != nil {
+		log.Printf("[electricity] %v, keeping history in memory", err)
+		store, _ = newConsumptionStore("")
+	} else if dir == "" {
+		log.Printf("[electricity] no history directory, consumption is kept in memory and fetched again on restart, set electricity.history_dir to keep it")
+	}
 	return &ElectricitySource{
-		apiURL:   cfg.ElectricityAPIURL,
-		clientID: cfg.ElectricityClientID,
-		username: cfg.ElectricityUsername,
-		password: cfg.ElectricityPassword,
+		apiURL:   cfg.Electricity.APIURL,
+		clientID: cfg.Electricity.ClientID,
+		username: cfg.Electricity.Username,
+		password: cfg.Electricity.Password,
+		prices:   cfg.Electricity.Prices,
+		offers:   cfg.Electricity.Offers,
+		backfill: cfg.Electricity.BackfillYears,
+		days:     cfg.Electricity.Days,
+		months:   cfg.Electricity.Months,
+		store:    store,
 		loc:      loc,
+		timeout:  cfg.Electricity.Timeout,
 	}
 }
 
 func (s *ElectricitySource) Name() string               { return "electricity" }
 func (s *ElectricitySource) DegradedTTL() time.Duration { return 48 * time.Hour }
+func (s *ElectricitySource) Timeout() time.Duration     { return s.timeout }
 
-func (s *ElectricitySource) Fetch() *Response {
-	if s.username == "" || s.password == "" {
-		return ErrorResponse("electricity not configured", time.Hour)
-	}
-
-	data, err := s.fetchData()
+func (s *ElectricitySource) Fetch(ctx context.Context) *Response {
+	data, backfilling, err := s.fetchData(ctx)
 	if err != nil {
 		log.Printf("[electricity] %v", err)
-		return ErrorResponse(err.Error(), 10*time.Minute)
+		return ErrorResponse(err.Error(), errorTTL(err, 10*time.Minute))
 	}
+	if backfilling {
+		return NewResponse(data, electricityBackfillTTL)
+	}
+	return s.response(data)
+}
 
-	now := time.Now().In(s.loc)
+// Response expiring at the next daily refresh once yesterday is available
+func (s *ElectricitySource) response(data *ElectricityData) *Response {
+	now := clock.Now().In(s.loc)
 	hour := now.Hour()
 
 	// Yesterday's data not yet available
@@ -98,60 +173,81 @@ func (s *ElectricitySource) Fetch() *Response {
 	return NewResponse(data, electricityRetryTTL)
 }
 
-// Fetch consumption once authenticated
-func (s *ElectricitySource) fetchData() (*ElectricityData, error) {
-	if err := s.ensureAuth(); err != nil {
-		return nil, fmt.Errorf("auth: %w", err)
+// Sync consumption once authenticated, then backfill older months if
+// configured. Returns whether the backfill has pages left.
+func (s *ElectricitySource) fetchData(ctx context.Context) (*ElectricityData, bool, error) {
+	if err := s.ensureAuth(ctx); err != nil {
+		return nil, false, fmt.Errorf("auth: %w", err)
+	}
+	if err := s.syncConsumption(ctx); err != nil {
+		return nil, false, err
+	}
+	backfilling := s.backfill > 0 && s.backfillConsumption(ctx)
+
+	days, months := s.store.snapshot()
+	data := &ElectricityData{
+		Days:   aggregateConsumption(days, s.days),
+		Months: aggregateConsumption(months, s.months),
 	}
-	return s.fetchConsumption()
+	s.prices.apply(data)
+	return data, backfilling, nil
 }
 
 // Ensure valid access token and service point ID
-func (s *ElectricitySource) ensureAuth() error {
+func (s *ElectricitySource) ensureAuth(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Check if token expires soon
-	if s.accessToken != "" && time.Now().Add(5*time.Minute).Before(s.tokenExpiry) {
+	if s.accessToken != "" && clock.Now().Add(5*time.Minute).Before(s.tokenExpiry) {
 		return nil
 	}
 
 	log.Printf("[electricity] authenticating")
+	metricElectricityLogins.Inc()
 	verifier, challenge := generatePKCE()
 
-	cookie, err := s.login()
+	cookie, err := s.login(ctx)
 	if err != nil {
 		return fmt.Errorf("login: %w", err)
 	}
 
-	code, err := s.authorize(cookie, challenge)
+	code, err := s.authorize(ctx, cookie, challenge)
 	if err != nil {
 		return fmt.Errorf("authorize: %w", err)
 	}
 
-	if err := s.exchangeToken(code, verifier); err != nil {
+	if err := s.exchangeToken(ctx, code, verifier); err != nil {
 		return fmt.Errorf("token: %w", err)
 	}
 
-	log.Printf("[electricity] authenticated (expires in %s)", time.Until(s.tokenExpiry).Round(time.Minute))
+	log.Printf("[electricity] authenticated (expires in %s)", s.tokenExpiry.Sub(clock.Now()).Round(time.Minute))
 
 	// TODO: check if servicePointID is still valid after token refresh
 	if s.servicePointID == "" {
-		if err := s.fetchServicePoint(); err != nil {
+		if err := s.fetchServicePoint(ctx); err != nil {
 			return fmt.Errorf("service point: %w", err)
 		}
 	}
 	return nil
 }
 
+// Forget the access token, so that the next ensureAuth logs in again
+func (s *ElectricitySource) resetToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken = ""
+	s.tokenExpiry = time.Time{}
+}
+
 // Login and obtain session cookie
-func (s *ElectricitySource) login() (*http.Cookie, error) {
+func (s *ElectricitySource) login(ctx context.Context) (*http.Cookie, error) {
 	var resp struct {
 		Code    string `json:"code"`
 		Libelle string `json:"libelle"`
 	}
 
-	httpResp, err := PostForm(s.apiURL+"/auth/externe/authentification", url.Values{
+	httpResp, err := PostForm(ctx, s.apiURL+"/auth/externe/authentification", url.Values{
 		"username":  {s.username},
 		"password":  {s.password},
 		"client_id": {s.clientID},
@@ -173,7 +269,7 @@ func (s *ElectricitySource) login() (*http.Cookie, error) {
 }
 
 // Obtain authorization code
-func (s *ElectricitySource) authorize(cookie *http.Cookie, challenge string) (string, error) {
+func (s *ElectricitySource) authorize(ctx context.Context, cookie *http.Cookie, challenge string) (string, error) {
 	query := url.Values{
 		"response_type":         {"code"},
 		"code_challenge":        {challenge},
@@ -181,7 +277,7 @@ func (s *ElectricitySource) authorize(cookie *http.Cookie, challenge string) (st
 		"client_id":             {s.clientID},
 	}
 
-	httpResp, err := GetRedirect(s.apiURL+"/auth/authorize-internet", query, nil, []*http.Cookie{cookie})
+	httpResp, err := GetRedirect(ctx, s.apiURL+"/auth/authorize-internet", query, nil, []*http.Cookie{cookie})
 	if err != nil {
 		return "", err
 	}
@@ -204,7 +300,7 @@ func (s *ElectricitySource) authorize(cookie *http.Cookie, challenge string) (st
 }
 
 // Exchange authorization code for access token
-func (s *ElectricitySource) exchangeToken(code, verifier string) error {
+func (s *ElectricitySource) exchangeToken(ctx context.Context, code, verifier string) error {
 	var resp struct {
 		AccessToken string `json:"access_token"`
 		TokenType   string `json:"token_type"`
@@ -212,7 +308,7 @@ func (s *ElectricitySource) exchangeToken(code, verifier string) error {
 		Error       string `json:"error"`
 	}
 
-	if _, err := PostForm(s.apiURL+"/auth/tokenUtilisateurInternet", url.Values{
+	if _, err := PostForm(ctx, s.apiURL+"/auth/tokenUtilisateurInternet", url.Values{
 		"client_id":     {s.clientID},
 		"code":          {code},
 		"grant_type":    {"authorization_code"},
@@ -226,12 +322,12 @@ func (s *ElectricitySource) exchangeToken(code, verifier string) error {
 	}
 
 	s.accessToken = resp.TokenType + " " + resp.AccessToken
-	s.tokenExpiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	s.tokenExpiry = clock.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
 	return nil
 }
 
 // Fetch service point ID (Point De Livraison)
-func (s *ElectricitySource) fetchServicePoint() error {
+func (s *ElectricitySource) fetchServicePoint(ctx context.Context) error {
 	var resp []struct {
 		ID             string `json:"id"`
 		PointDeService struct {
@@ -241,7 +337,7 @@ func (s *ElectricitySource) fetchServicePoint() error {
 
 	query := url.Values{"expand": {"pointDeService"}}
 	headers := http.Header{"Authorization": {s.accessToken}}
-	if _, err := GetJSON(s.apiURL+"/rest/produits/pointsAccesServicesClient", query, headers, nil, &resp, nil); err != nil {
+	if _, err := GetJSON(ctx, s.apiURL+"/rest/produits/pointsAccesServicesClient", query, headers, nil, &resp, nil); err != nil {
 		return err
 	}
 
@@ -274,15 +370,98 @@ type consumptionPeriod struct {
 	} `json:"blocFournisseur"`
 }
 
-// Fetch electricity consumption data
-func (s *ElectricitySource) fetchConsumption() (*ElectricityData, error) {
-	now := time.Now()
-	start := time.Date(now.Year(), now.Month()-2, 0, 0, 0, 0, 0, time.Local)
-	end := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+// Fetch the months since the latest stored day, at least the previous and
+// current months whose values are still being completed, or when nothing
+// is stored the last three months and at least the returned days and months
+func (s *ElectricitySource) syncConsumption(ctx context.Context) error {
+	now := clock.Now().In(s.loc)
+	start := time.Date(now.Year(), now.Month()-time.Month(max(2, s.months-1)), 1, 0, 0, 0, 0, s.loc)
+	if first := now.AddDate(0, 0, -s.days); first.Before(start) {
+		start = time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, s.loc)
+	}
+	if last := s.store.lastDay(); last != "" {
+		start = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, s.loc)
+		if t, err := time.ParseInLocation(time.DateOnly, last, s.loc); err == nil && t.Before(start) {
+			start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+		}
+	}
+	end := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, s.loc)
+
+	for page := start; page.Before(end); page = page.AddDate(0, electricityPageMonths, 0) {
+		pageEnd := page.AddDate(0, electricityPageMonths, 0)
+		if pageEnd.After(end) {
+			pageEnd = end
+		}
+		days, months, err := s.fetchConsumption(ctx, page, pageEnd)
+		if err != nil {
+			return err
+		}
+		if err := s.store.merge(days, months); err != nil {
+			log.Printf("[electricity] history: %v", err)
+		}
+	}
+	return nil
+}
 
+// Fetch pages of months older than the oldest covered one, until the
+// configured years are covered, SER has no older contract or consumption
+// for electricityBackfillEmptyPages pages, or the time budget of the
+// refresh is spent. Returns whether pages are left for the next
+// refresh; failures are retried on the next daily refresh.
+func (s *ElectricitySource) backfillConsumption(ctx context.Context) bool {
+	now := clock.Now().In(s.loc)
+	goal := time.Date(now.Year()-s.backfill, now.Month(), 1, 0, 0, 0, 0, s.loc)
+
+	for i := 0; i < electricityBackfillPages; i++ {
+		oldest, err := time.ParseInLocation("2006-01", s.store.oldest(), s.loc)
+		if err != nil || !oldest.After(goal) {
+			return false
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < s.timeout/3 {
+			return true
+		}
+
+		start := oldest.AddDate(0, -electricityPageMonths, 0)
+		if start.Before(goal) {
+			start = goal
+		}
+		days, months, err := s.fetchConsumption(ctx, start, oldest)
+		if err == nil && len(days) == 0 && len(months) == 0 {
+			if s.empty++; s.empty >= electricityBackfillEmptyPages {
+				err = errNoContractData
+			}
+		} else if err == nil {
+			s.empty = 0
+		}
+		if errors.Is(err, errNoContractData) {
+			log.Printf("[electricity] no consumption before %s, backfill done", oldest.Format("2006-01"))
+			if err := s.store.setBackfilled(goal.Format("2006-01")); err != nil {
+				log.Printf("[electricity] history: %v", err)
+			}
+			return false
+		}
+		if err != nil {
+			log.Printf("[electricity] backfill before %s: %v", oldest.Format("2006-01"), err)
+			return false
+		}
+		if err := s.store.merge(days, months); err != nil {
+			log.Printf("[electricity] history: %v", err)
+		}
+		if err := s.store.setBackfilled(start.Format("2006-01")); err != nil {
+			log.Printf("[electricity] history: %v", err)
+		}
+		log.Printf("[electricity] backfilled %s to %s, %d days", start.Format("2006-01"), oldest.AddDate(0, 0, -1).Format(time.DateOnly), len(days))
+	}
+	return true
+}
+
+// Fetch daily and monthly consumption between two local midnights. The
+// request starts the day before, as the app does, and values before start
+// are dropped since the monthly one would be partial.
+func (s *ElectricitySource) fetchConsumption(ctx context.Context, start, end time.Time) (days, months map[string]map[string]float64, err error) {
 	payload := map[string]any{
 		"typeObjet": "DonneesHistoriqueMesureRepresentation",
-		"dateDebut": start.Format(time.RFC3339),
+		"dateDebut": start.AddDate(0, 0, -1).Format(time.RFC3339),
 		"dateFin":   end.Format(time.RFC3339),
 		"pointAccesServicesClient": map[string]any{
 			"typeObjet": "produit.PointAccesServicesClient",
@@ -298,22 +477,49 @@ func (s *ElectricitySource) fetchConsumption() (*ElectricityData, error) {
 	}
 
 	reqURL := s.apiURL + "/rest/interfaces/" + strings.ToLower(s.clientID) + "/historiqueDeMesure"
-	headers := http.Header{"Authorization": {s.accessToken}}
-	if _, err := PostJSON(reqURL, payload, headers, nil, &resp, checkErrSER); err != nil {
-		return nil, err
+	if err := s.postAuthorized(ctx, reqURL, payload, &resp); err != nil {
+		return nil, nil, err
 	}
 
 	if len(resp.PeriodesActivite) == 0 {
-		return nil, fmt.Errorf("no contract data")
+		return nil, nil, errNoContractData
 	}
 
-	return s.parseConsumption(resp.PeriodesActivite), nil
+	days, months = parseConsumption(resp.PeriodesActivite)
+	for date := range days {
+		if date < start.Format(time.DateOnly) {
+			delete(days, date)
+		}
+	}
+	for month := range months {
+		if month < start.Format("2006-01") {
+			delete(months, month)
+		}
+	}
+	return days, months, nil
+}
+
+// POST to the SER API with the access token, authenticating again once if
+// SER rejects it: tokens can be revoked before their announced expiry
+func (s *ElectricitySource) postAuthorized(ctx context.Context, reqURL string, payload, dest any) error {
+	for retried := false; ; retried = true {
+		headers := http.Header{"Authorization": {s.accessToken}}
+		resp, err := PostJSON(ctx, reqURL, payload, headers, nil, dest, checkErrSER)
+		if err == nil || retried || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			return err
+		}
+		log.Printf("[electricity] access token rejected, authenticating again")
+		s.resetToken()
+		if err := s.ensureAuth(ctx); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
 }
 
-// Parse consumption data from API response
-func (s *ElectricitySource) parseConsumption(contracts []consumptionPeriod) *ElectricityData {
-	daily := make(map[string]map[string]float64)
-	monthly := make(map[string]map[string]float64)
+// Parse daily and monthly consumption per tariff from API response
+func parseConsumption(contracts []consumptionPeriod) (daily, monthly map[string]map[string]float64) {
+	daily = make(map[string]map[string]float64)
+	monthly = make(map[string]map[string]float64)
 
 	for _, contract := range contracts {
 		for _, poste := range contract.BlocFournisseur.PostesHorosaisonnier {
@@ -346,11 +552,38 @@ func (s *ElectricitySource) parseConsumption(contracts []consumptionPeriod) *Ele
 			}
 		}
 	}
+	return daily, monthly
+}
 
-	return &ElectricityData{
-		Days:   aggregateConsumption(daily, 14),
-		Months: aggregateConsumption(monthly, 2),
+// Consumption of each tariff present
+func (c Consumption) Tariffs() map[string]int {
+	values := make(map[string]int)
+	for tariff, v := range map[string]*int{
+		"BASE": c.BASE, "HC": c.HC, "HP": c.HP,
+		"BCHC": c.BCHC, "BCHP": c.BCHP,
+		"BUHC": c.BUHC, "BUHP": c.BUHP,
+		"RHC": c.RHC, "RHP": c.RHP,
+	} {
+		if v != nil {
+			values[tariff] = *v
+		}
+	}
+	return values
+}
+
+// Unrounded consumption of each tariff present, as stored. Data decoded
+// from JSON only has the rounded values.
+func (c Consumption) KWh() map[string]float64 {
+	if c.kwh != nil {
+		return c.kwh
 	}
+	return kwhValues(c.Tariffs())
+}
+
+// Every stored day of consumption, oldest first
+func (s *ElectricitySource) History() []Consumption {
+	days, _ := s.store.snapshot()
+	return aggregateConsumption(days, len(days))
 }
 
 // Generate PKCE verifier and challenge
@@ -363,7 +596,8 @@ func generatePKCE() (verifier, challenge string) {
 	return
 }
 
-// Aggregate consumption data into sorted slice with limit
+// Aggregate consumption data into sorted slice with limit, values being
+// rounded to the kWh for display
 func aggregateConsumption(m map[string]map[string]float64, limit int) []Consumption {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -377,13 +611,18 @@ func aggregateConsumption(m map[string]map[string]float64, limit int) []Consumpt
 
 	result := make([]Consumption, len(keys))
 	for i, k := range keys {
-		c := Consumption{Date: k}
+		c := Consumption{Date: k, kwh: make(map[string]float64)}
 		for tariff, value := range m[k] {
+			if value != 0 {
+				c.kwh[tariff] = value
+			}
 			v := int(value + 0.5)
 			if v == 0 {
 				continue
 			}
 			switch tariff {
+			case "BASE":
+				c.BASE = &v
 			case "HC":
 				c.HC = &v
 			case "HP":
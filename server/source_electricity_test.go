@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"strasboard/server/internal/fakeupstream"
+)
+
+// Electricity source talking to the fake upstream, counting logins.
+// history, if not nil, serves consumption instead of the fake upstream.
+func newFakeSER(t *testing.T, opts fakeupstream.Options, history http.HandlerFunc) (*fakeupstream.Server, *ElectricitySource, *atomic.Int32) {
+	t.Helper()
+	upstream, err := fakeupstream.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logins atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/auth/externe/authentification") {
+			logins.Add(1)
+		}
+		if history != nil && strings.HasSuffix(r.URL.Path, "/historiqueDeMesure") {
+			history(w, r)
+			return
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := defaultConfig()
+	cfg.Electricity.APIURL = srv.URL + "/ser"
+	cfg.Electricity.ClientID = "FAKE"
+	cfg.Electricity.Username = "user"
+	cfg.Electricity.Password = "pass"
+	return upstream, NewElectricitySource(cfg), &logins
+}
+
+func TestElectricityFetch(t *testing.T) {
+	_, s, logins := newFakeSER(t, fakeupstream.Options{Contract: "hchp"}, nil)
+	ctx := context.Background()
+
+	resp := s.Fetch(ctx)
+	data, ok := resp.Data.(*ElectricityData)
+	if resp.Error != "" || !ok {
+		t.Fatalf("fetch: %+v", resp)
+	}
+	if len(data.Days) != 14 || len(data.Months) != 2 {
+		t.Errorf("%d days and %d months, want 14 and 2", len(data.Days), len(data.Months))
+	}
+	if last := data.Days[len(data.Days)-1]; last.HC == nil || last.HP == nil || last.BASE != nil {
+		t.Errorf("last day %+v, want HC and HP", last)
+	}
+
+	// The token is kept between refreshes
+	if resp := s.Fetch(ctx); resp.Error != "" || logins.Load() != 1 {
+		t.Errorf("second fetch: %q, %d logins, want 1", resp.Error, logins.Load())
+	}
+}
+
+// SER revoking the token before its announced expiry triggers a new login
+func TestElectricityTokenRevoked(t *testing.T) {
+	upstream, s, logins := newFakeSER(t, fakeupstream.Options{Contract: "hchp", TokenTTL: 50 * time.Millisecond}, nil)
+	upstream.SetScenarios("ser-token-expiry")
+	ctx := context.Background()
+
+	if resp := s.Fetch(ctx); resp.Error != "" {
+		t.Fatalf("first fetch: %s", resp.Error)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if resp := s.Fetch(ctx); resp.Error != "" {
+		t.Errorf("fetch with a revoked token: %s", resp.Error)
+	}
+	if n := logins.Load(); n != 2 {
+		t.Errorf("%d logins, want 2", n)
+	}
+}
+
+func TestElectricityErrors(t *testing.T) {
+	tests := []struct {
+		scenario string
+		err      string
+		ttl      time.Duration
+	}{
+		{"ser-no-contract", "no contract data", 10 * time.Minute},
+		{"ser-error", "momentanément indisponibles", 10 * time.Minute},
+		{"ser-down", "server returned 503", errorRetryBase},
+		{"ser-login-failed", "auth: login: Identifiant ou mot de passe incorrect", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		upstream, s, _ := newFakeSER(t, fakeupstream.Options{Contract: "hchp"}, nil)
+		upstream.SetScenarios(tt.scenario)
+
+		resp := s.Fetch(context.Background())
+		if !strings.Contains(resp.Error, tt.err) {
+			t.Errorf("%s: %q, want %q", tt.scenario, resp.Error, tt.err)
+		}
+		if ttl := resp.ExpiresAt.Sub(clock.Now()); ttl <= tt.ttl-time.Second || ttl > tt.ttl {
+			t.Errorf("%s: retry in %s, want %s", tt.scenario, ttl, tt.ttl)
+		}
+	}
+}
+
+// SER consumption on the first day of every month since from, a contract
+// without consumption between gapFrom and gapTo, and no contract before
+// from. Pages starting before the gap are counted.
+func serHistory(from, gapFrom, gapTo string, beforeGap *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			DateDebut string `json:"dateDebut"`
+			DateFin   string `json:"dateFin"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		start, _ := time.Parse(time.RFC3339, req.DateDebut)
+		end, _ := time.Parse(time.RFC3339, req.DateFin)
+		start = start.AddDate(0, 0, 1)
+		if start.Format("2006-01") < gapFrom {
+			beforeGap.Add(1)
+		}
+		if end.AddDate(0, 0, -1).Format("2006-01") < from {
+			fmt.Fprint(w, `{"periodesActivite": []}`)
+			return
+		}
+
+		var daily, monthly []map[string]any
+		for m := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location()); m.Before(end); m = m.AddDate(0, 1, 0) {
+			if month := m.Format("2006-01"); month < from || (month >= gapFrom && month < gapTo) || m.Before(start) {
+				continue
+			}
+			daily = append(daily, map[string]any{"date": m.Format("02/01/2006"), "consommation": 10})
+			monthly = append(monthly, map[string]any{"annee": m.Year(), "mois": int(m.Month()), "consommation": 300})
+		}
+		postes := []map[string]any{}
+		if len(daily) > 0 {
+			postes = append(postes, map[string]any{
+				"etiquette":                 map[string]string{"mnemo": "BASE"},
+				"consommationsJournalieres": daily,
+				"consommationsMensuelles":   monthly,
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"periodesActivite": []map[string]any{{"blocFournisseur": map[string]any{"postesHorosaisonnier": postes}}},
+		})
+	}
+}
+
+// The backfill skips gaps in the consumption, and stops at the start of
+// the contract or after a year of pages without consumption. The pages
+// before the gap run down to the one without contract.
+func TestElectricityBackfill(t *testing.T) {
+	tests := []struct {
+		name           string
+		from, gapFrom  string
+		gapTo          string
+		months         []string // stored or not
+		stored         []bool
+		beforeGapPages int32
+	}{
+		{"gap of 6 months", "2022-01", "2024-01", "2024-07",
+			[]string{"2022-01", "2023-12", "2024-03", "2024-07"}, []bool{true, true, false, true}, 13},
+		{"no consumption before the last 6 months", "2000-01", "2000-01", "2025-07",
+			[]string{"2025-06", "2025-07"}, []bool{false, true}, 0},
+	}
+	for _, tt := range tests {
+		fakeClock(t, mustTime(t, "2026-01-15T12:00:00+01:00"))
+		var beforeGap atomic.Int32
+		_, s, _ := newFakeSER(t, fakeupstream.Options{Contract: "base"}, serHistory(tt.from, tt.gapFrom, tt.gapTo, &beforeGap))
+		s.backfill = 5
+
+		for i := 0; ; i++ {
+			resp := s.Fetch(context.Background())
+			if resp.Error != "" {
+				t.Fatalf("%s: %s", tt.name, resp.Error)
+			}
+			if resp.ExpiresAt.Sub(clock.Now()) > electricityBackfillTTL {
+				break
+			}
+			if i == 20 {
+				t.Fatalf("%s: backfill not done after %d refreshes", tt.name, i)
+			}
+		}
+
+		_, months := s.store.snapshot()
+		for i, month := range tt.months {
+			if _, ok := months[month]; ok != tt.stored[i] {
+				t.Errorf("%s: %s stored %v, want %v", tt.name, month, ok, tt.stored[i])
+			}
+		}
+		if oldest := s.store.oldest(); oldest != "2021-01" {
+			t.Errorf("%s: backfilled to %s, want 2021-01", tt.name, oldest)
+		}
+		if n := beforeGap.Load(); n != tt.beforeGapPages {
+			t.Errorf("%s: %d pages before the gap, want %d", tt.name, n, tt.beforeGapPages)
+		}
+	}
+}
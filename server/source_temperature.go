@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"log"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-const temperatureBufSize = 5
+const (
+	temperatureBufSize = 5
+
+	// Failed authentications allowed per client and window
+	pushMaxFailures   = 5
+	pushFailureWindow = 5 * time.Minute
+)
 
 // TemperatureSource receives push data from the sensor and serves
 // the median of the last few readings.
 type TemperatureSource struct {
+	sensors []SensorConfig
+	limiter *failureLimiter
+
 	mu       sync.RWMutex
 	temps    []float64
 	hums     []float64
@@ -35,15 +48,28 @@ type TemperatureData struct {
 	Location    string  `json:"location"`
 }
 
-func NewTemperatureSource(_ *Config) *TemperatureSource {
-	return &TemperatureSource{}
+func init() {
+	RegisterSource("temperature", func(cfg *Config) Source {
+		return NewTemperatureSource(cfg)
+	})
+}
+
+func NewTemperatureSource(cfg *Config) *TemperatureSource {
+	if len(cfg.Temperature.Sensors) == 0 {
+		log.Printf("[temperature] no sensors configured, pushes are not authenticated")
+	}
+	return &TemperatureSource{
+		sensors: cfg.Temperature.Sensors,
+		limiter: newFailureLimiter(pushMaxFailures, pushFailureWindow),
+	}
 }
 
 func (s *TemperatureSource) Name() string               { return "temperature" }
 func (s *TemperatureSource) DegradedTTL() time.Duration { return 4 * time.Hour }
+func (s *TemperatureSource) Timeout() time.Duration     { return time.Second }
 
 // Fetch returns the median reading or an error if no data is available.
-func (s *TemperatureSource) Fetch() *Response {
+func (s *TemperatureSource) Fetch(_ context.Context) *Response {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -60,23 +86,86 @@ func (s *TemperatureSource) Fetch() *Response {
 }
 
 // HandlePush returns an HTTP handler that accepts POST data from the sensor.
-func (s *TemperatureSource) HandlePush() http.HandlerFunc {
+// onPush is called with each accepted reading.
+func (s *TemperatureSource) HandlePush(onPush func(TemperaturePayload)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		ip := clientIP(r)
+		if wait := s.limiter.blocked(ip); wait > 0 {
+			metricTemperaturePushRejected.Inc("rate_limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+		sensor, ok := s.authenticate(r)
+		if !ok {
+			s.limiter.fail(ip)
+			metricTemperaturePushRejected.Inc("unauthorized")
+			log.Printf("[temperature] rejected push from %s: invalid token", ip)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="strasboard"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.limiter.reset(ip)
+
 		var p TemperaturePayload
 		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
 
+		// A sensor bound to a location may only report for it
+		if sensor != nil && sensor.Location != "" {
+			if p.Location != "" && p.Location != sensor.Location {
+				metricTemperaturePushRejected.Inc("location")
+				log.Printf("[temperature] rejected push from %s: location %q not allowed", sensor.Name, p.Location)
+				http.Error(w, "location not allowed", http.StatusForbidden)
+				return
+			}
+			p.Location = sensor.Location
+		}
+
 		s.push(p)
-		log.Printf("temperature: received %.1f °C, %.1f %% (%s)", p.Temperature, p.Humidity, p.Location)
+		identity := "anonymous"
+		if sensor != nil {
+			identity = sensor.Name
+		}
+		log.Printf("[temperature] %s: received %.1f °C, %.1f %% (%s)", identity, p.Temperature, p.Humidity, p.Location)
 		w.WriteHeader(http.StatusNoContent)
+
+		if onPush != nil {
+			onPush(p)
+		}
+	}
+}
+
+// authenticate matches the bearer token of a request against the configured
+// sensors. Without sensors, every request is accepted anonymously.
+func (s *TemperatureSource) authenticate(r *http.Request) (*SensorConfig, bool) {
+	if len(s.sensors) == 0 {
+		return nil, true
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return nil, false
 	}
+	for i := range s.sensors {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.sensors[i].Token)) == 1 {
+			return &s.sensors[i], true
+		}
+	}
+	return nil, false
+}
+
+// LastPush returns the time of the last accepted reading.
+func (s *TemperatureSource) LastPush() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastPush
 }
 
 func (s *TemperatureSource) push(p TemperaturePayload) {
@@ -90,7 +179,7 @@ func (s *TemperatureSource) push(p TemperaturePayload) {
 		s.hums = s.hums[1:]
 	}
 	s.location = p.Location
-	s.lastPush = time.Now()
+	s.lastPush = clock.Now()
 }
 
 // median returns the median of a float64 slice. The slice must not be empty.
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Push a reading with a token from a client address
+func push(s *TemperatureSource, ip, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/temperature", strings.NewReader(body))
+	r.RemoteAddr = ip + ":4321"
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.HandlePush(nil)(w, r)
+	return w
+}
+
+// Each sensor pushes with its own token, bound sensors only for their location
+func TestTemperaturePushTokens(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Temperature.Sensors = []SensorConfig{
+		{Name: "living", Token: "t-living", Location: "Living Room"},
+		{Name: "spare", Token: "t-spare"},
+	}
+	s := NewTemperatureSource(cfg)
+
+	tests := []struct {
+		name     string
+		token    string
+		body     string
+		code     int
+		location string
+	}{
+		{"no token", "", `{"temperature": 20}`, http.StatusUnauthorized, ""},
+		{"unknown token", "t-other", `{"temperature": 20}`, http.StatusUnauthorized, ""},
+		{"bound sensor", "t-living", `{"temperature": 20}`, http.StatusNoContent, "Living Room"},
+		{"bound sensor, its location", "t-living", `{"temperature": 21, "location": "Living Room"}`, http.StatusNoContent, "Living Room"},
+		{"bound sensor, other location", "t-living", `{"temperature": 5, "location": "Garden"}`, http.StatusForbidden, "Living Room"},
+		{"free sensor", "t-spare", `{"temperature": 22, "location": "Kitchen"}`, http.StatusNoContent, "Kitchen"},
+		{"bad payload", "t-spare", `{"temperature": "warm"}`, http.StatusBadRequest, "Kitchen"},
+	}
+	for _, tt := range tests {
+		w := push(s, "192.0.2.1", tt.token, tt.body)
+		if w.Code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if s.location != tt.location {
+			t.Errorf("%s: location %q, want %q", tt.name, s.location, tt.location)
+		}
+	}
+
+	data := s.Fetch(context.Background()).Data.(TemperatureData)
+	if data.Temperature != 21 {
+		t.Errorf("median %v, want 21 from the accepted readings", data.Temperature)
+	}
+}
+
+func TestTemperaturePushAnonymous(t *testing.T) {
+	s := NewTemperatureSource(defaultConfig())
+	if w := push(s, "192.0.2.1", "", `{"temperature": 19.5, "location": "Office"}`); w.Code != http.StatusNoContent {
+		t.Errorf("push without sensors configured: %d", w.Code)
+	}
+	if w := push(s, "192.0.2.1", "", "{"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: %d", w.Code)
+	}
+}
+
+// Clients are blocked after repeated invalid tokens, valid pushes reset the count
+func TestTemperaturePushLimiter(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Temperature.Sensors = []SensorConfig{{Name: "living", Token: "t-living"}}
+	s := NewTemperatureSource(cfg)
+	body := `{"temperature": 20}`
+
+	for i := 0; i < pushMaxFailures-1; i++ {
+		push(s, "192.0.2.1", "wrong", body)
+	}
+	if w := push(s, "192.0.2.1", "t-living", body); w.Code != http.StatusNoContent {
+		t.Fatalf("valid push under the limit: %d", w.Code)
+	}
+	for i := 0; i < pushMaxFailures; i++ {
+		if w := push(s, "192.0.2.1", "wrong", body); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d after a reset: %d, want 401", i, w.Code)
+		}
+	}
+
+	w := push(s, "192.0.2.1", "t-living", body)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("blocked client: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := push(s, "192.0.2.2", "t-living", body); w.Code != http.StatusNoContent {
+		t.Errorf("other client: %d", w.Code)
+	}
+}
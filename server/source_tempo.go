@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -20,7 +22,9 @@ type TempoSource struct {
 	apiURL    string
 	authURL   string
 	authToken string
+	store     *tempoStore
 	loc       *time.Location
+	timeout   time.Duration
 }
 
 // API response
@@ -31,34 +35,58 @@ type TempoDay struct {
 	Color string `json:"color"`
 }
 
+func init() {
+	RegisterSource("tempo", func(cfg *Config) Source {
+		if cfg.Tempo.AuthToken == "" {
+			return nil
+		}
+		return NewTempoSource(cfg)
+	})
+}
+
 func NewTempoSource(cfg *Config) *TempoSource {
 	loc, _ := time.LoadLocation("Europe/Paris")
 	if loc == nil {
 		loc = time.Local
 	}
+	// Stored with the temperature history, or kept in memory
+	dir := ""
+	if cfg.HistoryDir != "" {
+		dir = filepath.Join(cfg.HistoryDir, "tempo")
+	}
+	store, err := newTempoStore(dir)
+	if err != nil {
+		log.Printf("[tempo] %v, keeping history in memory", err)
+		store, _ = newTempoStore("")
+	} else if dir == "" {
+		log.Printf("[tempo] no history directory, day colours are kept in memory and fetched again on restart")
+	}
 	return &TempoSource{
-		apiURL:    cfg.TempoAPIURL,
-		authURL:   cfg.TempoAuthURL,
-		authToken: cfg.TempoAuthToken,
+		apiURL:    cfg.Tempo.APIURL,
+		authURL:   cfg.Tempo.AuthURL,
+		authToken: cfg.Tempo.AuthToken,
+		store:     store,
 		loc:       loc,
+		timeout:   cfg.Tempo.Timeout,
 	}
 }
 
 func (s *TempoSource) Name() string               { return "tempo" }
 func (s *TempoSource) DegradedTTL() time.Duration { return 24 * time.Hour }
+func (s *TempoSource) Timeout() time.Duration     { return s.timeout }
 
-func (s *TempoSource) Fetch() *Response {
-	if s.authToken == "" {
-		return ErrorResponse("tempo not configured", time.Hour)
-	}
-
-	data, err := s.fetchData()
+func (s *TempoSource) Fetch(ctx context.Context) *Response {
+	data, err := s.fetchData(ctx)
 	if err != nil {
 		log.Printf("[tempo] %v", err)
-		return ErrorResponse(err.Error(), 10*time.Minute)
+		return ErrorResponse(err.Error(), errorTTL(err, 10*time.Minute))
 	}
+	return s.response(data)
+}
 
-	now := time.Now().In(s.loc)
+// Response expiring at the next publication window
+func (s *TempoSource) response(data TempoData) *Response {
+	now := clock.Now().In(s.loc)
 	hour := now.Hour()
 
 	// Tomorrow's data not yet available
@@ -75,16 +103,25 @@ func (s *TempoSource) Fetch() *Response {
 	return NewResponse(data, tempoRetryTTL)
 }
 
-// Fetch tempo data from RTE
-func (s *TempoSource) fetchData() (TempoData, error) {
-	token, err := s.authenticate()
+// Fetch the colours of today and tomorrow from RTE. Days missing from the
+// store since the start of the season, or since the latest stored day, are
+// fetched along and stored for the calendar.
+func (s *TempoSource) fetchData(ctx context.Context) (TempoData, error) {
+	token, err := s.authenticate(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("auth: %w", err)
 	}
 
-	now := time.Now().In(s.loc)
+	now := clock.Now().In(s.loc)
 	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc)
 	endDate := today.AddDate(0, 0, 2)
+	startDate := tempoSeasonStart(today)
+	if last, err := time.ParseInLocation(time.DateOnly, s.store.lastDay(), s.loc); err == nil && !last.Before(startDate) {
+		startDate = last
+		if today.Before(startDate) {
+			startDate = today
+		}
+	}
 
 	var resp struct {
 		TempoLikeCalendars struct {
@@ -96,36 +133,54 @@ func (s *TempoSource) fetchData() (TempoData, error) {
 	}
 
 	query := url.Values{
-		"start_date": {today.Format(time.RFC3339)},
+		"start_date": {startDate.Format(time.RFC3339)},
 		"end_date":   {endDate.Format(time.RFC3339)},
 	}
 	headers := http.Header{"Authorization": {"Bearer " + token}}
-	if _, err := GetJSON(s.apiURL+"/tempo_like_calendars", query, headers, nil, &resp, checkErrRTE); err != nil {
+	if _, err := GetJSON(ctx, s.apiURL+"/tempo_like_calendars", query, headers, nil, &resp, checkErrRTE); err != nil {
 		return nil, err
 	}
 
-	if len(resp.TempoLikeCalendars.Values) == 0 {
-		return nil, fmt.Errorf("no tempo data")
-	}
-
-	data := make(TempoData, 0, len(resp.TempoLikeCalendars.Values))
+	fetched := make(TempoData, 0, len(resp.TempoLikeCalendars.Values))
+	data := TempoData{}
 	for _, v := range resp.TempoLikeCalendars.Values {
-		data = append(data, TempoDay{
+		day := TempoDay{
 			Date:  v.StartDate[:10],
 			Color: strings.ToLower(v.Value),
-		})
+		}
+		fetched = append(fetched, day)
+		if day.Date >= today.Format(time.DateOnly) {
+			data = append(data, day)
+		}
+	}
+	if err := s.store.merge(fetched); err != nil {
+		log.Printf("[tempo] history: %v", err)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no tempo data")
 	}
 	return data, nil
 }
 
+// Every stored colour, by date
+func (s *TempoSource) Colors() map[string]string {
+	return s.store.snapshot()
+}
+
+// Stored colours since the start of the current season, by date
+func (s *TempoSource) Season() TempoData {
+	return s.store.since(tempoSeasonStart(clock.Now().In(s.loc)).Format(time.DateOnly))
+}
+
 // Authenticate and obtain access token
-func (s *TempoSource) authenticate() (string, error) {
+func (s *TempoSource) authenticate(ctx context.Context) (string, error) {
 	var resp struct {
 		AccessToken string `json:"access_token"`
 	}
 
 	headers := http.Header{"Authorization": {"Basic " + s.authToken}}
-	if _, err := PostJSON(s.authURL, nil, headers, nil, &resp, nil); err != nil {
+	if _, err := PostJSON(ctx, s.authURL, nil, headers, nil, &resp, nil); err != nil {
 		return "", err
 	}
 
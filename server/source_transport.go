@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,17 +16,28 @@ import (
 const (
 	transportTTL     = 2 * time.Minute
 	transportLiveTTL = 20 * time.Second
+
+	// Live refreshes a client may send at once before being rate limited
+	transportLiveBurst = 3
 )
 
+var errQuotaExhausted = errors.New("daily quota exhausted")
+
 type TransportSource struct {
-	apiURL string
-	apiKey string
-	stops  []stopInfo
-	ready  bool
+	apiURL  string
+	apiKey  string
+	timeout time.Duration
+	stops   []stopInfo
+	ready   bool
+
+	// Per-client limit on live refreshes, daily limit on CTS calls
+	liveLimiter *rateLimiter
+	budget      *dailyBudget
 
 	// Live requests use shorter TTL but share the cache
-	mu    sync.RWMutex
-	cache map[int]*departureCache
+	mu       sync.RWMutex
+	cache    map[int]*departureCache
+	inflight flightGroup[int, []Destination]
 }
 
 type stopInfo struct {
@@ -42,7 +55,8 @@ type departureCache struct {
 
 // API response
 type TransportData struct {
-	Stops []StopData `json:"stops"`
+	Stops []StopData      `json:"stops"`
+	Quota *TransportQuota `json:"quota,omitempty"`
 }
 
 type StopData struct {
@@ -52,6 +66,15 @@ type StopData struct {
 	Color        string        `json:"color"`
 	ColorText    string        `json:"color_text"`
 	Destinations []Destination `json:"destinations"`
+	// Set on live refreshes only
+	Quota *TransportQuota `json:"quota,omitempty"`
+}
+
+// Daily budget of CTS calls, omitted without budget
+type TransportQuota struct {
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+	ResetsAt  string `json:"resets_at"`
 }
 
 type Destination struct {
@@ -64,83 +87,124 @@ type Departure struct {
 	Realtime bool   `json:"realtime"`
 }
 
+func init() {
+	RegisterSource("transport", func(cfg *Config) Source {
+		if cfg.Transport.APIKey == "" || len(cfg.Transport.Stops) == 0 {
+			return nil
+		}
+		return NewTransportSource(cfg)
+	})
+}
+
 func NewTransportSource(cfg *Config) *TransportSource {
 	s := &TransportSource{
-		apiURL: cfg.TransportAPIURL,
-		apiKey: cfg.TransportAPIKey,
-		cache:  make(map[int]*departureCache),
+		apiURL:  cfg.Transport.APIURL,
+		apiKey:  cfg.Transport.APIKey,
+		timeout: cfg.Transport.Timeout,
+		cache:   make(map[int]*departureCache),
+
+		liveLimiter: newRateLimiter(cfg.Transport.LiveRateLimit, transportLiveBurst),
+		budget:      budgets.get("transport", cfg.Transport.DailyBudget),
 	}
 
-	// Parse config into temporary resolution data
-	for _, entry := range strings.Split(cfg.TransportStops, ";") {
-		parts := strings.SplitN(strings.TrimSpace(entry), ",", 3)
-		if len(parts) == 3 {
-			s.stops = append(s.stops, stopInfo{
-				line:    parts[0],
-				name:    parts[1],
-				stopRef: parts[2],
-			})
-		} else if entry != "" {
-			log.Printf("[transport] invalid stop config: %q", entry)
-		}
+	// Copy config into temporary resolution data
+	for _, stop := range cfg.Transport.Stops {
+		s.stops = append(s.stops, stopInfo{
+			line:    stop.Line,
+			name:    stop.Stop,
+			stopRef: stop.Destination,
+		})
 	}
 	return s
 }
 
 func (s *TransportSource) Name() string               { return "transport" }
 func (s *TransportSource) DegradedTTL() time.Duration { return time.Hour }
+func (s *TransportSource) Timeout() time.Duration     { return s.timeout }
 
-func (s *TransportSource) Fetch() *Response {
-	if s.apiKey == "" {
-		return ErrorResponse("transport not configured", time.Hour)
-	}
-	if len(s.stops) == 0 {
-		return ErrorResponse("no stops configured", time.Hour)
-	}
+func (s *TransportSource) Fetch(ctx context.Context) *Response {
 	if !s.ready {
-		if err := s.resolveStops(); err != nil {
-			return ErrorResponse("resolve: "+err.Error(), time.Hour)
+		if err := s.resolveStops(ctx); err != nil {
+			return ErrorResponse("resolve: "+err.Error(), s.errorTTL(err, time.Hour))
+		}
+	}
+
+	// Refresh less often when the daily budget runs low
+	resolved := 0
+	for i := range s.stops {
+		if s.stops[i].stopRef != "" {
+			resolved++
 		}
 	}
+	ttl := s.budget.stretch(transportTTL, max(resolved, 1))
 
 	var stops []StopData
+	var lastErr error
 	for i := range s.stops {
 		if s.stops[i].stopRef == "" {
 			continue
 		}
-		data := s.getStopData(i, transportTTL)
-		if data != nil {
-			stops = append(stops, *data)
+		data, err := s.getStopData(ctx, i, ttl)
+		if err != nil {
+			lastErr = err
+			continue
 		}
+		stops = append(stops, *data)
 	}
 
 	if len(stops) == 0 {
-		return ErrorResponse("no departure data", 5*time.Minute)
+		return ErrorResponse("no departure data", s.errorTTL(lastErr, 5*time.Minute))
 	}
-	return NewResponse(TransportData{Stops: stops}, transportTTL)
+	return NewResponse(TransportData{Stops: stops, Quota: s.quota()}, ttl)
 }
 
-func (s *TransportSource) FetchLive(id int) *Response {
-	if s.apiKey == "" {
-		return ErrorResponse("transport not configured", time.Hour)
-	}
+func (s *TransportSource) FetchLive(ctx context.Context, id int) *Response {
 	if id < 0 || id >= len(s.stops) || !s.ready || s.stops[id].stopRef == "" {
 		return ErrorResponse("invalid stop", time.Minute)
 	}
 
-	data := s.getStopData(id, transportLiveTTL)
-	if data == nil {
-		return ErrorResponse("fetch failed", time.Minute)
+	ttl := s.budget.stretch(transportLiveTTL, 1)
+	data, err := s.getStopData(ctx, id, ttl)
+	if err != nil {
+		return ErrorResponse("fetch failed", s.errorTTL(err, time.Minute))
 	}
-	return NewResponse(data, transportLiveTTL)
+	data.Quota = s.quota()
+	return NewResponse(data, ttl)
+}
+
+// Check the per-client rate limit of live refreshes
+func (s *TransportSource) AllowLive(client string) (bool, time.Duration) {
+	return s.liveLimiter.allow(client)
+}
+
+// Current state of the daily budget, nil without budget
+func (s *TransportSource) quota() *TransportQuota {
+	if s.budget.limit == 0 {
+		return nil
+	}
+	remaining, resetsAt := s.budget.remaining()
+	return &TransportQuota{
+		Limit:     s.budget.limit,
+		Remaining: remaining,
+		ResetsAt:  resetsAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// Error TTL, lasting until the budget reset once it is exhausted
+func (s *TransportSource) errorTTL(err error, fallback time.Duration) time.Duration {
+	if errors.Is(err, errQuotaExhausted) {
+		_, resetsAt := s.budget.remaining()
+		return resetsAt.Sub(clock.Now())
+	}
+	return errorTTL(err, fallback)
 }
 
 // Build StopData from static info and cached departures
-func (s *TransportSource) getStopData(id int, maxAge time.Duration) *StopData {
-	destinations, err := s.getDepartures(id, maxAge)
+func (s *TransportSource) getStopData(ctx context.Context, id int, maxAge time.Duration) (*StopData, error) {
+	destinations, err := s.getDepartures(ctx, id, maxAge)
 	if err != nil {
 		log.Printf("[transport] stop %s %s: %v", s.stops[id].line, s.stops[id].name, err)
-		return nil
+		return nil, err
 	}
 
 	stop := &s.stops[id]
@@ -151,33 +215,40 @@ func (s *TransportSource) getStopData(id int, maxAge time.Duration) *StopData {
 		Color:        stop.color,
 		ColorText:    stop.colorText,
 		Destinations: destinations,
-	}
+	}, nil
 }
 
 // Get departures with caching
-func (s *TransportSource) getDepartures(id int, maxAge time.Duration) ([]Destination, error) {
+func (s *TransportSource) getDepartures(ctx context.Context, id int, maxAge time.Duration) ([]Destination, error) {
 	s.mu.RLock()
 	cached := s.cache[id]
 	s.mu.RUnlock()
 
-	if cached != nil && time.Since(cached.fetchedAt) < maxAge {
+	if cached != nil && clock.Now().Sub(cached.fetchedAt) < maxAge {
 		return cached.destinations, nil
 	}
 
-	destinations, err := s.fetchDepartures(id)
-	if err != nil {
-		return nil, err
-	}
+	// Concurrent misses for the same stop share one request
+	return s.inflight.Do(ctx, id, func(ctx context.Context) ([]Destination, error) {
+		destinations, err := s.fetchDepartures(ctx, id)
+		// Out of budget: older departures are better than none
+		if errors.Is(err, errQuotaExhausted) && cached != nil {
+			return cached.destinations, nil
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	s.mu.Lock()
-	s.cache[id] = &departureCache{destinations: destinations, fetchedAt: time.Now()}
-	s.mu.Unlock()
+		s.mu.Lock()
+		s.cache[id] = &departureCache{destinations: destinations, fetchedAt: clock.Now()}
+		s.mu.Unlock()
 
-	return destinations, nil
+		return destinations, nil
+	})
 }
 
 // Fetch departures from CTS
-func (s *TransportSource) fetchDepartures(id int) ([]Destination, error) {
+func (s *TransportSource) fetchDepartures(ctx context.Context, id int) ([]Destination, error) {
 	stop := &s.stops[id]
 
 	var resp struct {
@@ -202,7 +273,10 @@ func (s *TransportSource) fetchDepartures(id int) ([]Destination, error) {
 		"MinimumStopVisitsPerLine": {"4"},
 	}
 	headers := http.Header{"Authorization": {"Basic " + s.apiKey}}
-	if _, err := GetJSON(s.apiURL+"/stop-monitoring", query, headers, nil, &resp, checkErrCTS); err != nil {
+	if !s.budget.spend() {
+		return nil, errQuotaExhausted
+	}
+	if _, err := GetJSON(ctx, s.apiURL+"/stop-monitoring", query, headers, nil, &resp, checkErrCTS); err != nil {
 		return nil, err
 	}
 
@@ -212,7 +286,7 @@ func (s *TransportSource) fetchDepartures(id int) ([]Destination, error) {
 		for _, visit := range resp.ServiceDelivery.StopMonitoringDelivery[0].MonitoredStopVisit {
 			journey := visit.MonitoredVehicleJourney
 			depTime, err := time.Parse(time.RFC3339, journey.MonitoredCall.ExpectedDepartureTime)
-			// TODO: check if depTime.Before(time.Now()) condition is necessary
+			// TODO: check if depTime.Before(clock.Now()) condition is necessary
 			if err != nil {
 				continue
 			}
@@ -243,7 +317,7 @@ type AnnotatedStopPointRef struct {
 }
 
 // Resolve stop references defined in config
-func (s *TransportSource) resolveStops() error {
+func (s *TransportSource) resolveStops(ctx context.Context) error {
 	var resp struct {
 		StopPointsDelivery struct {
 			AnnotatedStopPointRef []AnnotatedStopPointRef
@@ -252,7 +326,10 @@ func (s *TransportSource) resolveStops() error {
 
 	query := url.Values{"includeLinesDestinations": {"true"}}
 	headers := http.Header{"Authorization": {"Basic " + s.apiKey}}
-	if _, err := GetJSON(s.apiURL+"/stoppoints-discovery", query, headers, nil, &resp, checkErrCTS); err != nil {
+	if !s.budget.spend() {
+		return errQuotaExhausted
+	}
+	if _, err := GetJSON(ctx, s.apiURL+"/stoppoints-discovery", query, headers, nil, &resp, checkErrCTS); err != nil {
 		return err
 	}
 
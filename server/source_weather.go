@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -17,11 +18,12 @@ const (
 )
 
 type WeatherSource struct {
-	apiURL string
-	lat    string
-	lon    string
-	tz     string
-	loc    *time.Location
+	apiURL  string
+	lat     string
+	lon     string
+	tz      string
+	loc     *time.Location
+	timeout time.Duration
 
 	mu      sync.Mutex
 	current *weatherCache[[]WeatherCurrent]
@@ -35,7 +37,7 @@ type weatherCache[T any] struct {
 }
 
 func (c *weatherCache[T]) valid() bool {
-	return c != nil && time.Now().Before(c.expiresAt)
+	return c != nil && clock.Now().Before(c.expiresAt)
 }
 
 // API response
@@ -68,53 +70,60 @@ type WeatherDay struct {
 	Code    int     `json:"code"`
 }
 
+func init() {
+	RegisterSource("weather", func(cfg *Config) Source {
+		if cfg.Weather.APIURL == "" {
+			return nil
+		}
+		return NewWeatherSource(cfg)
+	})
+}
+
 func NewWeatherSource(cfg *Config) *WeatherSource {
-	loc, _ := time.LoadLocation(cfg.WeatherTimezone)
+	loc, _ := time.LoadLocation(cfg.Weather.Timezone)
 	if loc == nil {
 		loc = time.Local
 	}
 	return &WeatherSource{
-		apiURL: cfg.WeatherAPIURL,
-		lat:    fmt.Sprintf("%.4f", cfg.WeatherLatitude),
-		lon:    fmt.Sprintf("%.4f", cfg.WeatherLongitude),
-		tz:     cfg.WeatherTimezone,
-		loc:    loc,
+		apiURL:  cfg.Weather.APIURL,
+		lat:     fmt.Sprintf("%.4f", cfg.Weather.Latitude),
+		lon:     fmt.Sprintf("%.4f", cfg.Weather.Longitude),
+		tz:      cfg.Weather.Timezone,
+		loc:     loc,
+		timeout: cfg.Weather.Timeout,
 	}
 }
 
 func (s *WeatherSource) Name() string               { return "weather" }
 func (s *WeatherSource) DegradedTTL() time.Duration { return 24 * time.Hour }
+func (s *WeatherSource) Timeout() time.Duration     { return s.timeout }
 
-func (s *WeatherSource) Fetch() *Response {
-	if s.apiURL == "" {
-		return ErrorResponse("weather not configured", time.Hour)
-	}
-
+func (s *WeatherSource) Fetch(ctx context.Context) *Response {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	var lastErr error
 	if !s.current.valid() {
-		if err := s.fetchCurrent(); err != nil {
+		if err := s.fetchCurrent(ctx); err != nil {
 			log.Printf("[weather] fetch current: %v", err)
 			lastErr = err
 		}
 	}
 	if !s.hourly.valid() {
-		if err := s.fetchHourly(); err != nil {
+		if err := s.fetchHourly(ctx); err != nil {
 			log.Printf("[weather] fetch hourly: %v", err)
 			lastErr = err
 		}
 	}
 	if !s.daily.valid() {
-		if err := s.fetchDaily(); err != nil {
+		if err := s.fetchDaily(ctx); err != nil {
 			log.Printf("[weather] fetch daily: %v", err)
 			lastErr = err
 		}
 	}
 
 	if !s.current.valid() && !s.hourly.valid() && !s.daily.valid() {
-		return ErrorResponse("weather unavailable: "+lastErr.Error(), 5*time.Minute)
+		return ErrorResponse("weather unavailable: "+lastErr.Error(), errorTTL(lastErr, 5*time.Minute))
 	}
 
 	data := WeatherData{}
@@ -127,9 +136,13 @@ func (s *WeatherSource) Fetch() *Response {
 	if s.daily.valid() {
 		data.Daily = s.filterDaily()
 	}
+	return s.response(data)
+}
 
-	// Refresh filtered data at midnight
-	now := time.Now().In(s.loc)
+// Response expiring after weatherResponseTTL, or at midnight so that the
+// filtered days move forward
+func (s *WeatherSource) response(data WeatherData) *Response {
+	now := clock.Now().In(s.loc)
 	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, s.loc)
 	if midnight.Sub(now) < weatherResponseTTL {
 		return NewResponseUntil(data, midnight)
@@ -138,7 +151,7 @@ func (s *WeatherSource) Fetch() *Response {
 }
 
 // Fetch 15-minutely weather data
-func (s *WeatherSource) fetchCurrent() error {
+func (s *WeatherSource) fetchCurrent(ctx context.Context) error {
 	var resp struct {
 		Minutely15 struct {
 			Time        []string  `json:"time"`
@@ -158,7 +171,7 @@ func (s *WeatherSource) fetchCurrent() error {
 		"longitude":            {s.lon},
 		"timezone":             {s.tz},
 	}
-	if _, err := GetJSON(s.apiURL, query, nil, nil, &resp, checkErrOpenMeteo); err != nil {
+	if _, err := GetJSON(ctx, s.apiURL, query, nil, nil, &resp, checkErrOpenMeteo); err != nil {
 		return err
 	}
 	if len(resp.Minutely15.Time) == 0 {
@@ -176,12 +189,12 @@ func (s *WeatherSource) fetchCurrent() error {
 		}
 	}
 
-	s.current = &weatherCache[[]WeatherCurrent]{data: slots, expiresAt: time.Now().Add(weatherCurrentTTL)}
+	s.current = &weatherCache[[]WeatherCurrent]{data: slots, expiresAt: clock.Now().Add(weatherCurrentTTL)}
 	return nil
 }
 
 // Fetch hourly weather data
-func (s *WeatherSource) fetchHourly() error {
+func (s *WeatherSource) fetchHourly(ctx context.Context) error {
 
 	var resp struct {
 		Hourly struct {
@@ -194,7 +207,7 @@ func (s *WeatherSource) fetchHourly() error {
 	}
 
 	// Fetch from hour-4 to day+3+TTL
-	now := time.Now().In(s.loc)
+	now := clock.Now().In(s.loc)
 	startDate := now.Add(-4 * time.Hour).Format(time.DateOnly)
 	endDate := now.AddDate(0, 0, 3).Add(weatherHourlyTTL + weatherResponseTTL).Format(time.DateOnly)
 	query := url.Values{
@@ -206,7 +219,7 @@ func (s *WeatherSource) fetchHourly() error {
 		"longitude":  {s.lon},
 		"timezone":   {s.tz},
 	}
-	if _, err := GetJSON(s.apiURL, query, nil, nil, &resp, checkErrOpenMeteo); err != nil {
+	if _, err := GetJSON(ctx, s.apiURL, query, nil, nil, &resp, checkErrOpenMeteo); err != nil {
 		return err
 	}
 
@@ -221,12 +234,12 @@ func (s *WeatherSource) fetchHourly() error {
 		}
 	}
 
-	s.hourly = &weatherCache[[]WeatherHour]{data: hours, expiresAt: time.Now().Add(weatherHourlyTTL)}
+	s.hourly = &weatherCache[[]WeatherHour]{data: hours, expiresAt: clock.Now().Add(weatherHourlyTTL)}
 	return nil
 }
 
 // Fetch daily weather data
-func (s *WeatherSource) fetchDaily() error {
+func (s *WeatherSource) fetchDaily(ctx context.Context) error {
 	var resp struct {
 		Daily struct {
 			Time        []string  `json:"time"`
@@ -237,7 +250,7 @@ func (s *WeatherSource) fetchDaily() error {
 	}
 
 	// Fetch from day+4 to day+7+TTL
-	now := time.Now().In(s.loc)
+	now := clock.Now().In(s.loc)
 	startDate := now.AddDate(0, 0, 4).Format(time.DateOnly)
 	endDate := now.AddDate(0, 0, 7).Add(weatherDailyTTL + weatherResponseTTL).Format(time.DateOnly)
 	query := url.Values{
@@ -248,7 +261,7 @@ func (s *WeatherSource) fetchDaily() error {
 		"longitude":  {s.lon},
 		"timezone":   {s.tz},
 	}
-	if _, err := GetJSON(s.apiURL, query, nil, nil, &resp, checkErrOpenMeteo); err != nil {
+	if _, err := GetJSON(ctx, s.apiURL, query, nil, nil, &resp, checkErrOpenMeteo); err != nil {
 		return err
 	}
 
@@ -262,14 +275,14 @@ func (s *WeatherSource) fetchDaily() error {
 		}
 	}
 
-	s.daily = &weatherCache[[]WeatherDay]{data: days, expiresAt: time.Now().Add(weatherDailyTTL)}
+	s.daily = &weatherCache[[]WeatherDay]{data: days, expiresAt: clock.Now().Add(weatherDailyTTL)}
 	return nil
 }
 
 // Filter current weather
 func (s *WeatherSource) filterCurrent() WeatherCurrent {
 	// Find closest slot to midpoint of TTL interval
-	now := time.Now().In(s.loc)
+	now := clock.Now().In(s.loc)
 	midpoint := now.Add((weatherResponseTTL - 15*time.Minute) / 2)
 
 	for _, slot := range s.current.data {
@@ -285,7 +298,7 @@ func (s *WeatherSource) filterCurrent() WeatherCurrent {
 // Filter hourly data
 func (s *WeatherSource) filterHourly() []WeatherHour {
 	// Filter from hour-4 to day+4 at 00:00
-	now := time.Now().In(s.loc)
+	now := clock.Now().In(s.loc)
 	start := now.Add(-4 * time.Hour)
 	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc).AddDate(0, 0, 4)
 
@@ -303,7 +316,7 @@ func (s *WeatherSource) filterHourly() []WeatherHour {
 // Filter daily data
 func (s *WeatherSource) filterDaily() []WeatherDay {
 	// Filter from day+4 to day+7
-	now := time.Now().In(s.loc)
+	now := clock.Now().In(s.loc)
 	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc).AddDate(0, 0, 4)
 	end := start.AddDate(0, 0, 3)
 
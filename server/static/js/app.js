@@ -12,10 +12,18 @@ function entryTotal(entry) {
 // Response parsing
 function parseResponse(resp, transform) {
   const data = resp?.data ? transform(resp.data) : null;
-  const error = resp?.error || (!data ? 'No data' : null);
+  let error = resp?.error || (!data ? 'No data' : null);
+  if (error && data && resp.stale) error += ` (data from ${formatAge(resp.age_seconds)} ago)`;
   return { data, error };
 }
 
+function formatAge(seconds) {
+  const minutes = Math.round((seconds ?? 0) / 60);
+  if (minutes < 60) return `${minutes} min`;
+  if (minutes < 48 * 60) return `${Math.round(minutes / 60)} h`;
+  return `${Math.round(minutes / 1440)} d`;
+}
+
 const parseWeather = (resp) => parseResponse(resp, (d) => {
   return formatWeather(d.current ?? null, d.hourly ?? [], d.daily ?? []);
 });
@@ -140,6 +148,7 @@ const parseTransport = (resp) => parseResponse(resp, (d) => ({
 function parseElectricity(resp) {
   const normalize = (entry) => ({
     date: entry.date,
+    base: entry.BASE ?? 0,
     hp: entry.HP ?? 0, hc: entry.HC ?? 0,
     rhp: entry.RHP ?? 0, rhc: entry.RHC ?? 0,
     bchp: entry.BCHP ?? 0, bchc: entry.BCHC ?? 0,
@@ -215,10 +224,30 @@ document.addEventListener('alpine:init', () => {
     init() {
       document.documentElement.lang = navigator.language || 'en';
       this.refresh();
+      this.subscribe();
       setInterval(() => this.refresh(), 60_000);
       setInterval(() => { this.now = Date.now(); }, 1_000);
     },
 
+    subscribe() {
+      if (!window.EventSource) return;
+      const parsers = {
+        weather: parseWeather,
+        temperature: parseTemperature,
+        transport: parseTransport,
+        electricity: parseElectricity,
+        tempo: parseTempo,
+      };
+      const stream = new EventSource('/api/stream');
+      Object.entries(parsers).forEach(([name, parse]) => {
+        stream.addEventListener(name, (e) => {
+          const resp = JSON.parse(e.data);
+          this[name] = parse(resp);
+          this.timestamp = resp.timestamp;
+        });
+      });
+    },
+
     async refresh() {
       try {
         const all = await fetch('/api/all').then(r => r.json());
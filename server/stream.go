@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamHistorySize = 64
+	streamBufferSize  = 16
+	streamKeepAlive   = 30 * time.Second
+)
+
+// Stream broadcasts changed source responses to Server-Sent Events clients
+// and keeps recent events so that reconnecting clients can resume.
+type Stream struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []streamEvent
+	subscribers map[chan streamEvent]struct{}
+}
+
+type streamEvent struct {
+	id     uint64
+	source string
+	data   []byte
+}
+
+func NewStream() *Stream {
+	return &Stream{subscribers: make(map[chan streamEvent]struct{})}
+}
+
+// Cache listener publishing responses that differ from the previous one
+func (s *Stream) OnSet(key string, prev, resp *Response) {
+	if sameResponse(prev, resp) {
+		return
+	}
+	s.Publish(key, resp)
+}
+
+// Send a response to all subscribers
+func (s *Stream) Publish(source string, resp *Response) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[stream] encode %s: %v", source, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	ev := streamEvent{id: s.lastID, source: source, data: data}
+	s.history = append(s.history, ev)
+	if len(s.history) > streamHistorySize {
+		s.history = s.history[1:]
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// Drop slow clients, they will reconnect with Last-Event-ID
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Disconnect all subscribers, e.g. on shutdown
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Register a subscriber and return events missed since lastID along
+// with the current event ID. ok is false when the history no longer
+// covers lastID, e.g. after a server restart.
+func (s *Stream) subscribe(lastID uint64) (ch chan streamEvent, missed []streamEvent, currentID uint64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch = make(chan streamEvent, streamBufferSize)
+	s.subscribers[ch] = struct{}{}
+
+	if lastID > s.lastID {
+		return ch, nil, s.lastID, false
+	}
+	if lastID < s.lastID && (len(s.history) == 0 || s.history[0].id > lastID+1) {
+		return ch, nil, s.lastID, false
+	}
+	for _, ev := range s.history {
+		if ev.id > lastID {
+			missed = append(missed, ev)
+		}
+	}
+	return ch, missed, s.lastID, true
+}
+
+// Remove a subscriber
+func (s *Stream) unsubscribe(ch chan streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// HTTP handler streaming events, optionally filtered with ?sources=a,b
+func (s *Stream) Handler(cache *Cache, sources *SourceSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		filter := make(map[string]bool)
+		for _, name := range strings.Split(r.URL.Query().Get("sources"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				filter[name] = true
+			}
+		}
+		wanted := func(source string) bool {
+			return len(filter) == 0 || filter[source]
+		}
+
+		var lastID uint64
+		resume := false
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+				lastID = id
+				resume = true
+			}
+		}
+
+		ch, missed, currentID, ok := s.subscribe(lastID)
+		defer s.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		if resume && ok {
+			for _, ev := range missed {
+				if wanted(ev.source) {
+					writeEvent(w, ev)
+				}
+			}
+		} else {
+			// Fresh client or history gap: send current state of each source
+			for name := range sources.All() {
+				if !wanted(name) {
+					continue
+				}
+				if resp := cache.Peek(name); resp != nil {
+					if data, err := json.Marshal(resp); err == nil {
+						writeEvent(w, streamEvent{id: currentID, source: name, data: data})
+					}
+				}
+			}
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case ev, open := <-ch:
+				if !open {
+					return
+				}
+				if wanted(ev.source) {
+					writeEvent(w, ev)
+					flusher.Flush()
+				}
+			}
+		}
+	}
+}
+
+// Write a single event in SSE format
+func writeEvent(w http.ResponseWriter, ev streamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.id, ev.source, ev.data)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamSubscribe(t *testing.T) {
+	s := NewStream()
+	ch, missed, current, ok := s.subscribe(0)
+	if !ok || len(missed) != 0 || current != 0 {
+		t.Errorf("empty stream: %d missed, current %d, ok %v", len(missed), current, ok)
+	}
+	s.unsubscribe(ch)
+
+	for i := 0; i < streamHistorySize+10; i++ {
+		s.Publish("tempo", NewResponse(i, time.Hour))
+	}
+	last := uint64(streamHistorySize + 10)
+
+	tests := []struct {
+		name       string
+		lastID     uint64
+		ok         bool
+		missed     int
+		firstEvent uint64
+	}{
+		{"up to date", last, true, 0, 0},
+		{"a few events behind", last - 3, true, 3, last - 2},
+		{"oldest kept event next", last - streamHistorySize, true, streamHistorySize, last - streamHistorySize + 1},
+		{"history gap", last - streamHistorySize - 1, false, 0, 0},
+		{"never connected", 0, false, 0, 0},
+		{"ahead, server restarted", last + 5, false, 0, 0},
+	}
+	for _, tt := range tests {
+		ch, missed, current, ok := s.subscribe(tt.lastID)
+		s.unsubscribe(ch)
+		if ok != tt.ok || len(missed) != tt.missed || current != last {
+			t.Errorf("%s: %d missed, current %d, ok %v, want %d missed, current %d, ok %v", tt.name, len(missed), current, ok, tt.missed, last, tt.ok)
+			continue
+		}
+		if len(missed) > 0 && missed[0].id != tt.firstEvent {
+			t.Errorf("%s: first missed event %d, want %d", tt.name, missed[0].id, tt.firstEvent)
+		}
+	}
+}
+
+// Unchanged responses are not published, slow subscribers are dropped
+func TestStreamPublish(t *testing.T) {
+	s := NewStream()
+	resp := NewResponse("a", time.Hour)
+	s.OnSet("tempo", nil, resp)
+	s.OnSet("tempo", resp, NewResponse("a", time.Hour))
+	if s.lastID != 1 {
+		t.Errorf("%d events, want 1 for unchanged data", s.lastID)
+	}
+
+	ch, _, _, _ := s.subscribe(s.lastID)
+	for i := 0; i <= streamBufferSize; i++ {
+		s.Publish("tempo", NewResponse(i, time.Hour))
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != streamBufferSize {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, streamBufferSize)
+	}
+}
+
+// Read events until n are received, as "id source data" lines
+func readEvents(t *testing.T, url, lastEventID string, n int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var events []string
+	var ev []string
+	sc := bufio.NewScanner(resp.Body)
+	for len(events) < n && sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			events = append(events, strings.Join(ev, " "))
+			ev = nil
+			continue
+		}
+		if _, v, ok := strings.Cut(line, ": "); ok && !strings.HasPrefix(line, ":") {
+			if strings.HasPrefix(line, "data: ") {
+				v = strings.Split(strings.TrimPrefix(v, `{"data":`), ",")[0]
+			}
+			ev = append(ev, v)
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("got %v, want %d events: %v", events, n, sc.Err())
+	}
+	return events
+}
+
+func TestStreamHandler(t *testing.T) {
+	cache := NewCache()
+	sources := NewSourceSet(map[string]Source{
+		"tempo":   &testSource{name: "tempo"},
+		"weather": &testSource{name: "weather"},
+	})
+	s := NewStream()
+	cache.OnSet(s.OnSet)
+	cache.Set("tempo", NewResponse("red", time.Hour), time.Hour)
+	cache.Set("weather", NewResponse("sunny", time.Hour), time.Hour)
+	cache.Set("tempo", NewResponse("blue", time.Hour), time.Hour)
+
+	srv := httptest.NewServer(s.Handler(cache, sources))
+	defer srv.Close()
+
+	// Resuming replays missed events of the wanted sources
+	got := readEvents(t, srv.URL+"?sources=tempo", "1", 1)
+	if got[0] != `3 tempo "blue"` {
+		t.Errorf("resumed %v, want the missed tempo event", got)
+	}
+
+	// A fresh client gets the current state, then live events
+	subscribers := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.subscribers)
+	}
+	eventually(t, "the resumed client to leave", func() bool { return subscribers() == 0 })
+	done := make(chan []string)
+	go func() { done <- readEvents(t, srv.URL+"?sources=weather", "", 2) }()
+	eventually(t, "the client to subscribe", func() bool { return subscribers() == 1 })
+	cache.Set("tempo", NewResponse("white", time.Hour), time.Hour)
+	cache.Set("weather", NewResponse("rain", time.Hour), time.Hour)
+	got = <-done
+	if got[0] != `3 weather "sunny"` || got[1] != `5 weather "rain"` {
+		t.Errorf("fresh client got %v, want the current weather then the update", got)
+	}
+
+	// An unknown event ID, e.g. after a restart, gets the current state
+	got = readEvents(t, srv.URL+"?sources=tempo", "99", 1)
+	if got[0] != `5 tempo "white"` {
+		t.Errorf("after restart %v, want the current tempo state", got)
+	}
+}
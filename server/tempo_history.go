@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tempoStore keeps the colour of every Tempo day fetched from RTE, by date
+// (YYYY-MM-DD). With a directory, colours are persisted in colors.csv, with
+// date and colour records, rewritten on change.
+type tempoStore struct {
+	dir string // empty to keep colours in memory only
+
+	mu     sync.Mutex
+	colors map[string]string
+}
+
+// Open the store in dir, in memory only if empty
+func newTempoStore(dir string) (*tempoStore, error) {
+	s := &tempoStore{dir: dir, colors: make(map[string]string)}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("tempo history: %w", err)
+	}
+	err := readRecords(filepath.Join(dir, "colors.csv"), func(rec []string) {
+		if len(rec) == 2 {
+			s.colors[rec[0]] = rec[1]
+		}
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("tempo history: %w", err)
+	}
+	log.Printf("[tempo] history opened %s, %d days", dir, len(s.colors))
+	return s, nil
+}
+
+// Record fetched colours, replacing those of the same days
+func (s *tempoStore) merge(data TempoData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, day := range data {
+		if s.colors[day.Date] != day.Color {
+			s.colors[day.Date] = day.Color
+			changed = true
+		}
+	}
+	if !changed || s.dir == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, date := range sortedKeys(s.colors) {
+		w.Write([]string{date, s.colors[date]})
+	}
+	w.Flush()
+	return writeFileAtomic(filepath.Join(s.dir, "colors.csv"), buf.Bytes())
+}
+
+// Latest stored day, empty if none
+func (s *tempoStore) lastDay() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := ""
+	for date := range s.colors {
+		last = max(last, date)
+	}
+	return last
+}
+
+// Copy of the stored colours, by date
+func (s *tempoStore) snapshot() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.colors)
+}
+
+// Stored days from a date (YYYY-MM-DD, inclusive), by date
+func (s *tempoStore) since(from string) TempoData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := TempoData{}
+	for _, date := range sortedKeys(s.colors) {
+		if date >= from {
+			data = append(data, TempoDay{Date: date, Color: s.colors[date]})
+		}
+	}
+	return data
+}
+
+// First day of the Tempo season containing t, which runs from September 1
+// to August 31
+func tempoSeasonStart(t time.Time) time.Time {
+	year := t.Year()
+	if t.Month() < time.September {
+		year--
+	}
+	return time.Date(year, time.September, 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTempoStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newTempoStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.merge(TempoData{{Date: "2026-09-02", Color: "white"}, {Date: "2026-08-31", Color: "blue"}})
+	s.merge(TempoData{{Date: "2026-09-02", Color: "red"}, {Date: "2026-09-01", Color: "blue"}})
+
+	s, err = newTempoStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TempoData{{Date: "2026-09-01", Color: "blue"}, {Date: "2026-09-02", Color: "red"}}
+	if got := s.since("2026-09-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("since September 1: %v, want %v", got, want)
+	}
+	if last := s.lastDay(); last != "2026-09-02" {
+		t.Errorf("last day %s", last)
+	}
+}
+
+func TestTempoSeasonStart(t *testing.T) {
+	loc := paris(t)
+	tests := []struct{ date, want string }{
+		{"2026-08-31", "2025-09-01"},
+		{"2026-09-01", "2026-09-01"},
+		{"2027-01-15", "2026-09-01"},
+	}
+	for _, tt := range tests {
+		d, _ := time.ParseInLocation(time.DateOnly, tt.date, loc)
+		if got := tempoSeasonStart(d).Format(time.DateOnly); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.date, got, tt.want)
+		}
+	}
+}